@@ -2,6 +2,8 @@
 package artifacts
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 
@@ -9,31 +11,43 @@ import (
 	"github.com/dimes/zbuild/model"
 )
 
+const (
+	digestPrefix = "sha256:"
+)
+
 // Transfer transfers an artifact from source to the destination. Note: This does not explicitly
 // update the source set.
 func Transfer(source Manager, destination Manager, artifact *model.Artifact) error {
+	_, err := TransferWithDigest(source, destination, artifact)
+	return err
+}
+
+// TransferWithDigest is like Transfer, but also returns the digest of the transferred bytes, e.g.
+// sha256:2c26b46b...
+func TransferWithDigest(source Manager, destination Manager, artifact *model.Artifact) (string, error) {
 	buildlog.Infof("Transferring %+v from %+v to %+v", artifact, source, destination)
 	reader, err := source.OpenReader(artifact)
 	if err != nil {
-		return fmt.Errorf("Error opening reader to source for %s: %+v", artifact.String(), err)
+		return "", fmt.Errorf("Error opening reader to source for %s: %+v", artifact.String(), err)
 	}
 	defer reader.Close()
 
 	writer, err := destination.OpenWriter(artifact)
 	if err != nil {
-		return fmt.Errorf("Error opening writer to destination for %s: %+v", artifact.String(), err)
+		return "", fmt.Errorf("Error opening writer to destination for %s: %+v", artifact.String(), err)
 	}
 	defer writer.Close()
 
-	if _, err = io.Copy(writer, reader); err != nil {
-		return fmt.Errorf("Error copying source to destination for %s: %+v", artifact.String(), err)
+	hash := sha256.New()
+	if _, err = io.Copy(io.MultiWriter(writer, hash), reader); err != nil {
+		return "", fmt.Errorf("Error copying source to destination for %s: %+v", artifact.String(), err)
 	}
 
 	if err := writer.Close(); err != nil {
-		return fmt.Errorf("Error closing writer: %+v", err)
+		return "", fmt.Errorf("Error closing writer: %+v", err)
 	}
 
 	buildlog.Infof("Transfer complete")
 
-	return nil
+	return digestPrefix + hex.EncodeToString(hash.Sum(nil)), nil
 }
@@ -3,6 +3,7 @@ package golang
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -16,10 +17,9 @@ import (
 )
 
 const (
-	goType    = "go"
-	srcDir    = "src"
-	binDir    = "bin"
-	envFormat = "%s=%s"
+	goType     = "go"
+	srcDir     = "src"
+	runtimeDir = "runtime"
 )
 
 // Buildfile contains Go specific build options
@@ -43,34 +43,63 @@ func (b *Builder) Type() string {
 	return goType
 }
 
+// Version returns the version of this builder
+func (b *Builder) Version() string {
+	return "2"
+}
+
 // Build implements the Builder's Build method.
 //
 // Go builds consist of compiling all the code (to make sure it builds)
-// and then copying the source files to the build directory.
+// and then copying the source files to the build directory. Packages with
+// a go.mod are built as modules, and other packages in GOPATH mode.
 func (b *Builder) Build(workspace string, parsedBuildfile *model.ParsedBuildfile) error {
 	buildlog.Infof("Building Go package %s", parsedBuildfile.Package.String())
-	env, err := generateEnvironment(workspace, parsedBuildfile)
+	buildEnv, err := local.GetBuildEnv(workspace, parsedBuildfile)
 	if err != nil {
 		return fmt.Errorf("Error generating build environment: %+v", err)
 	}
 
+	if err := b.Env(workspace, parsedBuildfile, buildEnv); err != nil {
+		return err
+	}
+	env := buildEnv.Environ()
+
 	goBuildfile := &Buildfile{}
 	if err := yaml.Unmarshal(parsedBuildfile.RawBuildfile, goBuildfile); err != nil {
 		return fmt.Errorf("Error parsing go buildfile: %+v", err)
 	}
 
-	cmd := exec.Command("go", "build", filepath.Join(".", "..."))
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Dir = parsedBuildfile.AbsoluteWorkingDir
-	cmd.Env = env
-	if err := cmd.Run(); err != nil {
+	module := isModule(parsedBuildfile)
+	buildArgs := []string{"build"}
+	if module {
+		// The package's own go.mod is never changed. Instead, a copy pointing at the modules of the
+		// dependencies is used
+		tempDir, err := ioutil.TempDir(os.TempDir(), "gobuild")
+		if err != nil {
+			return fmt.Errorf("Error generating temp build directory: %+v", err)
+		}
+		defer os.RemoveAll(tempDir)
+
+		modfile, err := writeModfile(workspace, parsedBuildfile, tempDir)
+		if err != nil {
+			return fmt.Errorf("Error generating go.mod for %s: %+v", parsedBuildfile.Package.String(), err)
+		}
+		buildArgs = append(buildArgs, "-modfile="+modfile)
+	}
+
+	if err := runGo(parsedBuildfile, env, append(buildArgs, "./...")...); err != nil {
 		return fmt.Errorf("Error building %s: %+v", parsedBuildfile.Package.String(), err)
 	}
 
-	absoluteBinDir := filepath.Join(parsedBuildfile.AbsoluteBuildDir, binDir)
+	absoluteBinDir := filepath.Join(parsedBuildfile.AbsoluteBuildDir, model.BinDir)
 	if len(goBuildfile.Go.Targets) > 0 {
 		os.Mkdir(absoluteBinDir, os.ModePerm)
+
+		// Targets are executables, so they are packaged with everything they need at runtime
+		if err := packageRuntimeDependencies(workspace, parsedBuildfile); err != nil {
+			return err
+		}
 	}
 
 	for _, target := range goBuildfile.Go.Targets {
@@ -80,16 +109,16 @@ func (b *Builder) Build(workspace string, parsedBuildfile *model.ParsedBuildfile
 			return fmt.Errorf("Could not determine executable name for target %s", target)
 		}
 
-		cmd := exec.Command("go", "build", "-o", filepath.Join(absoluteBinDir, targetName), target)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		cmd.Dir = parsedBuildfile.AbsoluteWorkingDir
-		cmd.Env = env
-		if err := cmd.Run(); err != nil {
+		targetArgs := append(append([]string{}, buildArgs...), "-o", filepath.Join(absoluteBinDir, targetName), target)
+		if err := runGo(parsedBuildfile, env, targetArgs...); err != nil {
 			return fmt.Errorf("Error building target %s: %+v", target, err)
 		}
 	}
 
+	if module {
+		return copyModuleSources(parsedBuildfile)
+	}
+
 	buildlog.Infof("Copying source files to build directory %s", parsedBuildfile.AbsoluteBuildDir)
 	absoluteSrcOutput := filepath.Join(parsedBuildfile.AbsoluteBuildDir, srcDir)
 	absoluteSrcInput := filepath.Join(parsedBuildfile.AbsoluteWorkingDir, srcDir)
@@ -102,16 +131,108 @@ func (b *Builder) Build(workspace string, parsedBuildfile *model.ParsedBuildfile
 	return nil
 }
 
-func generateEnvironment(workspace string, parsedBuildfile *model.ParsedBuildfile) ([]string, error) {
+// packageRuntimeDependencies copies the build output of each runtime dependency that isn't compiled
+// into the executables to build/runtime/<namespace>/<name>, so the build output contains everything
+// needed to run them
+func packageRuntimeDependencies(workspace string, parsedBuildfile *model.ParsedBuildfile) error {
+	runtimeEntries, err := local.GetBuildpathEntries(workspace, parsedBuildfile.Package,
+		local.RuntimeDependencyResolver)
+	if err != nil {
+		return fmt.Errorf("Error resolving runtime dependencies: %+v", err)
+	}
+
+	compileEntries, err := local.GetBuildpathEntries(workspace, parsedBuildfile.Package,
+		local.CompileDependencyResolver)
+	if err != nil {
+		return fmt.Errorf("Error resolving compile dependencies: %+v", err)
+	}
+
+	compiled := make(map[string]bool)
+	for _, entry := range compileEntries {
+		compiled[entry.Location] = true
+	}
+
+	for _, entry := range runtimeEntries[1:] {
+		if compiled[entry.Location] {
+			continue
+		}
+
+		destination := filepath.Join(parsedBuildfile.AbsoluteBuildDir, runtimeDir, entry.Artifact.Namespace,
+			entry.Artifact.Name)
+		buildlog.Infof("Packaging runtime dependency %s", entry.Artifact.String())
+		if err := copyutil.Copy(entry.OutputDir(), destination); err != nil {
+			return fmt.Errorf("Error packaging runtime dependency %s: %+v", entry.Artifact.String(), err)
+		}
+	}
+
+	return nil
+}
+
+// runGo runs the go command in the package's directory
+func runGo(parsedBuildfile *model.ParsedBuildfile, env []string, args ...string) error {
+	cmd := exec.Command("go", args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Dir = parsedBuildfile.AbsoluteWorkingDir
+	cmd.Env = env
+	return cmd.Run()
+}
+
+// Toolchain returns the version of go and the platform it builds for, e.g.
+// "go version go1.12 darwin/amd64 linux/amd64 cgo=1" when cross compiling from macOS
+func (b *Builder) Toolchain(env *local.BuildEnv) (string, error) {
+	version, err := outputGo(env, "version")
+	if err != nil {
+		return "", err
+	}
+
+	target, err := outputGo(env, "env", "GOOS", "GOARCH", "CGO_ENABLED")
+	if err != nil {
+		return "", err
+	}
+
+	fields := strings.Fields(target)
+	if len(fields) != 3 {
+		return "", fmt.Errorf("Unexpected output of go env: %s", target)
+	}
+
+	return fmt.Sprintf("%s %s/%s cgo=%s", version, fields[0], fields[1], fields[2]), nil
+}
+
+// outputGo runs the go command with the build environment and returns its trimmed output
+func outputGo(env *local.BuildEnv, args ...string) (string, error) {
+	cmd := exec.Command("go", args...)
+	cmd.Env = env.Environ()
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("Error running go %s: %+v", strings.Join(args, " "), err)
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
+
+// Env selects the build mode. Modules can only use the modules provided by zbuild dependencies, so
+// nothing is downloaded. Other packages are built in GOPATH mode, with GOPATH set to the compile
+// closure
+func (b *Builder) Env(workspace string, parsedBuildfile *model.ParsedBuildfile, env *local.BuildEnv) error {
+	origin := goType + " builder"
+	if isModule(parsedBuildfile) {
+		env.Set("GO111MODULE", "on", origin)
+		env.Set("GOFLAGS", strings.TrimSpace(env.Get("GOFLAGS")+" -mod=mod"), origin)
+		env.Set("GOPROXY", "off", origin)
+		env.Set("GOWORK", "off", origin)
+		return nil
+	}
+
 	gopath, err := local.GetBuildpath(
 		workspace,
 		parsedBuildfile.Package,
 		local.CompileDependencyResolver)
 	if err != nil {
-		return nil, fmt.Errorf("Error getting GOPATH: %+v", err)
+		return fmt.Errorf("Error getting GOPATH: %+v", err)
 	}
 
-	env := make([]string, 0)
-	env = append(env, fmt.Sprintf(envFormat, "GOPATH", strings.Join(gopath, string(os.PathListSeparator))))
-	return env, nil
+	env.Set("GOPATH", strings.Join(gopath, string(os.PathListSeparator)), origin)
+	env.Set("GO111MODULE", "off", origin)
+	return nil
 }
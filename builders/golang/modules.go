@@ -0,0 +1,246 @@
+package golang
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dimes/zbuild/buildlog"
+	"github.com/dimes/zbuild/copyutil"
+	"github.com/dimes/zbuild/local"
+	"github.com/dimes/zbuild/model"
+)
+
+const (
+	goModFileName = "go.mod"
+	goSumFileName = "go.sum"
+)
+
+// goMod contains the parts of a go.mod file the builder needs
+type goMod struct {
+	module   string          // The module path
+	replaced map[string]bool // The module paths with replace directives
+}
+
+// isModule returns true if the package is a Go module, i.e. it has a go.mod next to its build file
+func isModule(parsedBuildfile *model.ParsedBuildfile) bool {
+	_, err := os.Stat(filepath.Join(parsedBuildfile.AbsoluteWorkingDir, goModFileName))
+	return err == nil
+}
+
+// readGoMod parses the module path and replace directives of the go.mod file
+func readGoMod(location string) (*goMod, error) {
+	goModBytes, err := ioutil.ReadFile(location)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading %s: %+v", location, err)
+	}
+
+	parsed := &goMod{replaced: make(map[string]bool)}
+	inReplaceBlock := false
+	scanner := bufio.NewScanner(bytes.NewReader(goModBytes))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if comment := strings.Index(line, "//"); comment >= 0 {
+			line = line[:comment]
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch {
+		case inReplaceBlock && fields[0] == ")":
+			inReplaceBlock = false
+		case inReplaceBlock:
+			parsed.replaced[strings.Trim(fields[0], `"`)] = true
+		case fields[0] == "module" && len(fields) > 1:
+			parsed.module = strings.Trim(fields[1], `"`)
+		case fields[0] == "replace" && len(fields) > 1 && fields[1] == "(":
+			inReplaceBlock = true
+		case fields[0] == "replace" && len(fields) > 1:
+			parsed.replaced[strings.Trim(fields[1], `"`)] = true
+		}
+	}
+
+	if parsed.module == "" {
+		return nil, fmt.Errorf("%s doesn't declare a module path", location)
+	}
+
+	return parsed, nil
+}
+
+// getDependencyModules returns the directories of the Go modules provided by the package's compile
+// closure, keyed by their module paths. Workspace packages provide their own directory if it is a
+// module. Other artifacts provide every module in the src directory of their build output, which is
+// where this builder puts the sources of module packages
+func getDependencyModules(workspace string, parsedBuildfile *model.ParsedBuildfile) (map[string]string, error) {
+	entries, err := local.GetBuildpathEntries(workspace, parsedBuildfile.Package, local.CompileDependencyResolver)
+	if err != nil {
+		return nil, fmt.Errorf("Error resolving compile dependencies: %+v", err)
+	}
+
+	modules := make(map[string]string)
+	provider := make(map[string]string)
+	for _, entry := range entries[1:] {
+		moduleDirs := make([]string, 0)
+		if entry.Origin == local.OriginWorkspace {
+			if _, err := os.Stat(filepath.Join(entry.Location, goModFileName)); err == nil {
+				moduleDirs = append(moduleDirs, entry.Location)
+			}
+		} else if moduleDirs, err = findModules(filepath.Join(entry.OutputDir(), srcDir)); err != nil {
+			return nil, err
+		}
+
+		for _, moduleDir := range moduleDirs {
+			dependencyMod, err := readGoMod(filepath.Join(moduleDir, goModFileName))
+			if err != nil {
+				return nil, err
+			}
+
+			if previous, ok := provider[dependencyMod.module]; ok {
+				return nil, fmt.Errorf("Module %s is provided by both %s and %s", dependencyMod.module, previous,
+					entry.Artifact.String())
+			}
+
+			provider[dependencyMod.module] = entry.Artifact.String()
+			modules[dependencyMod.module] = moduleDir
+		}
+	}
+
+	return modules, nil
+}
+
+// findModules returns the directories below dir that contain a go.mod file. Modules aren't searched
+// for nested modules
+func findModules(dir string) ([]string, error) {
+	moduleDirs := make([]string, 0)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return moduleDirs, nil
+	}
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() {
+			return nil
+		}
+
+		if _, err := os.Stat(filepath.Join(path, goModFileName)); err == nil {
+			moduleDirs = append(moduleDirs, path)
+			return filepath.SkipDir
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error searching %s for Go modules: %+v", dir, err)
+	}
+
+	return moduleDirs, nil
+}
+
+// writeModfile writes a copy of the package's go.mod to the directory, with replace directives that
+// point every module provided by zbuild dependencies to its directory. The package's go.sum is copied
+// next to it. Returns the location of the new go.mod, which is meant to be passed to go with -modfile
+func writeModfile(workspace string, parsedBuildfile *model.ParsedBuildfile, dir string) (string, error) {
+	goModLocation := filepath.Join(parsedBuildfile.AbsoluteWorkingDir, goModFileName)
+	packageMod, err := readGoMod(goModLocation)
+	if err != nil {
+		return "", err
+	}
+
+	modules, err := getDependencyModules(workspace, parsedBuildfile)
+	if err != nil {
+		return "", err
+	}
+
+	modulePaths := make([]string, 0, len(modules))
+	for modulePath := range modules {
+		modulePaths = append(modulePaths, modulePath)
+	}
+	sort.Strings(modulePaths)
+
+	goModBytes, err := ioutil.ReadFile(goModLocation)
+	if err != nil {
+		return "", fmt.Errorf("Error reading %s: %+v", goModLocation, err)
+	}
+
+	modfile := bytes.NewBuffer(goModBytes)
+	fmt.Fprintf(modfile, "\n// Modules provided by zbuild dependencies\n")
+	for _, modulePath := range modulePaths {
+		if packageMod.replaced[modulePath] {
+			buildlog.Warningf("%s replaces %s itself, so the zbuild dependency providing it isn't used",
+				goModLocation, modulePath)
+			continue
+		}
+		fmt.Fprintf(modfile, "replace %s => %s\n", modulePath, modules[modulePath])
+	}
+
+	modfileLocation := filepath.Join(dir, goModFileName)
+	if err := ioutil.WriteFile(modfileLocation, modfile.Bytes(), 0644); err != nil {
+		return "", fmt.Errorf("Error writing %s: %+v", modfileLocation, err)
+	}
+
+	goSumLocation := filepath.Join(parsedBuildfile.AbsoluteWorkingDir, goSumFileName)
+	if _, err := os.Stat(goSumLocation); err == nil {
+		if err := copyutil.Copy(goSumLocation, filepath.Join(dir, goSumFileName)); err != nil {
+			return "", err
+		}
+	}
+
+	return modfileLocation, nil
+}
+
+// copyModuleSources copies the module's sources to src/<module path> in the build directory, so
+// dependents can find the module, and GOPATH packages can import its packages. Hidden files, the
+// build directory and zbuild's own files aren't copied
+func copyModuleSources(parsedBuildfile *model.ParsedBuildfile) error {
+	packageMod, err := readGoMod(filepath.Join(parsedBuildfile.AbsoluteWorkingDir, goModFileName))
+	if err != nil {
+		return err
+	}
+
+	packageDir := parsedBuildfile.AbsoluteWorkingDir
+	outputDir := filepath.Join(parsedBuildfile.AbsoluteBuildDir, srcDir, filepath.FromSlash(packageMod.module))
+	buildlog.Infof("Copying module %s to build directory %s", packageMod.module, outputDir)
+	return filepath.Walk(packageDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if path == packageDir {
+			return os.MkdirAll(outputDir, 0755)
+		}
+
+		if path == parsedBuildfile.AbsoluteBuildDir || strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		relativePath, err := filepath.Rel(packageDir, path)
+		if err != nil {
+			return err
+		}
+
+		if relativePath == model.BuildfileName || relativePath == model.LockfileName {
+			return nil
+		}
+
+		destination := filepath.Join(outputDir, relativePath)
+		if info.IsDir() {
+			return os.MkdirAll(destination, 0755)
+		}
+
+		return copyutil.Copy(path, destination)
+	})
+}
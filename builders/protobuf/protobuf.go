@@ -7,6 +7,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 
 	"github.com/dimes/zbuild/buildlog"
 	"github.com/dimes/zbuild/copyutil"
@@ -33,6 +34,11 @@ func (b *Builder) Type() string {
 	return protobufType
 }
 
+// Version returns the version of this builder
+func (b *Builder) Version() string {
+	return "1"
+}
+
 // Build compiles the protocol buffers to make sure the syntax is correct
 func (b *Builder) Build(workspace string, parsedBuildfile *model.ParsedBuildfile) error {
 	buildlog.Infof("Building Protocol Buffer package %s", parsedBuildfile.Package.String())
@@ -54,7 +60,12 @@ func (b *Builder) Build(workspace string, parsedBuildfile *model.ParsedBuildfile
 	}
 	defer os.RemoveAll(tempDir)
 
-	err = runProtoc(parsedBuildfile.AbsoluteWorkingDir, protoPaths, protoFiles, "--cpp_out", tempDir)
+	env, err := local.GetBuildEnv(workspace, parsedBuildfile)
+	if err != nil {
+		return fmt.Errorf("Error generating build environment: %+v", err)
+	}
+
+	err = runProtoc(parsedBuildfile.AbsoluteWorkingDir, protoPaths, env, protoFiles, "--cpp_out", tempDir)
 	if err != nil {
 		return err
 	}
@@ -71,7 +82,27 @@ func (b *Builder) Build(workspace string, parsedBuildfile *model.ParsedBuildfile
 	return nil
 }
 
-func runProtoc(dir string, protoPaths []string, protoFiles []string, extraArgs ...string) error {
+// Toolchain returns the version of protoc, e.g. libprotoc 3.21.12
+func (b *Builder) Toolchain(env *local.BuildEnv) (string, error) {
+	return protocVersion(env)
+}
+
+// protocVersion returns the version of the protoc in the build environment's PATH
+func protocVersion(env *local.BuildEnv) (string, error) {
+	cmd := exec.Command("protoc", "--version")
+	cmd.Env = env.Environ()
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("Error running protoc --version: %+v", err)
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
+
+// runProtoc runs protoc in dir with the build environment, whose PATH includes the plugins provided
+// by tool dependencies, e.g. protoc-gen-go
+func runProtoc(dir string, protoPaths []string, env *local.BuildEnv, protoFiles []string,
+	extraArgs ...string) error {
 	args := make([]string, 0)
 	for _, protoPath := range protoPaths {
 		args = append(args, []string{"-I", filepath.Join(protoPath, srcDir)}...)
@@ -84,6 +115,7 @@ func runProtoc(dir string, protoPaths []string, protoFiles []string, extraArgs .
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	cmd.Dir = dir
+	cmd.Env = env.Environ()
 	if err := cmd.Run(); err != nil {
 		return fmt.Errorf("Error building protocol buffers in %s: %+v", dir, err)
 	}
@@ -50,6 +50,17 @@ func (p *Protogen) Type() string {
 	return protogenType
 }
 
+// Version returns the version of this builder
+func (p *Protogen) Version() string {
+	return "1"
+}
+
+// Toolchain returns the version of protoc. Plugins such as protoc-gen-go come from tool dependencies,
+// which are part of the fingerprint
+func (p *Protogen) Toolchain(env *local.BuildEnv) (string, error) {
+	return protocVersion(env)
+}
+
 // Build compiles the protocol buffers to make sure the syntax is correct
 func (p *Protogen) Build(workspace string, parsedBuildfile *model.ParsedBuildfile) error {
 	protogenBuildfile := &ProtogenBuildfile{}
@@ -67,8 +78,8 @@ func (p *Protogen) Build(workspace string, parsedBuildfile *model.ParsedBuildfil
 		return fmt.Errorf("Unknown lang %s", lang)
 	}
 
-	artifactDir, err := local.GetArtifactLocation(parsedBuildfile.AbsoluteWorkingDir,
-		protogenBuildfile.Protogen.Source)
+	source := parsedBuildfile.Package.Qualify(protogenBuildfile.Protogen.Source)
+	artifactDir, err := local.GetArtifactLocation(parsedBuildfile.AbsoluteWorkingDir, source)
 	if err != nil {
 		return fmt.Errorf("Error getting source location: %+v", err)
 	}
@@ -79,12 +90,17 @@ func (p *Protogen) Build(workspace string, parsedBuildfile *model.ParsedBuildfil
 		return fmt.Errorf("Error listing proto files in %s: %+v", protoDir, err)
 	}
 
-	protoPaths, err := local.GetBuildpath(workspace, protogenBuildfile.Protogen.Source,
+	protoPaths, err := local.GetBuildpath(workspace, source,
 		local.CompileDependencyResolver)
 	if err != nil {
 		return fmt.Errorf("Error getting proto path: %+v", err)
 	}
 
+	env, err := local.GetBuildEnv(workspace, parsedBuildfile)
+	if err != nil {
+		return fmt.Errorf("Error generating build environment: %+v", err)
+	}
+
 	outputDir := filepath.Join(parsedBuildfile.AbsoluteBuildDir, langOpt.outputDir)
 	if err := os.MkdirAll(outputDir, 0755); err != nil {
 		return fmt.Errorf("Error making output directory %s: %+v", outputDir, err)
@@ -93,6 +109,7 @@ func (p *Protogen) Build(workspace string, parsedBuildfile *model.ParsedBuildfil
 	err = runProtoc(
 		parsedBuildfile.AbsoluteWorkingDir,
 		protoPaths,
+		env,
 		protoFiles,
 		langOpt.flag, outputDir)
 	if err != nil {
@@ -4,6 +4,7 @@ package buildlog
 import (
 	"fmt"
 	"os"
+	"strings"
 )
 
 // LogLevel is an interface that determines what should be logged at various log levels
@@ -53,11 +54,31 @@ func (l *logLevel) File() *os.File {
 	return l.file
 }
 
+// ParseLogLevel returns the log level with the given name, i.e. debug, info, warning or error
+func ParseLogLevel(name string) (LogLevel, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return Debug, nil
+	case "info":
+		return Info, nil
+	case "warning":
+		return Warning, nil
+	case "error":
+		return Error, nil
+	}
+	return nil, fmt.Errorf("Unknown log level %s. Expected debug, info, warning or error", name)
+}
+
 // SetLogLevel sets the log level
 func SetLogLevel(level LogLevel) {
 	currentLevel = level
 }
 
+// GetLogLevel returns the current log level
+func GetLogLevel() LogLevel {
+	return currentLevel
+}
+
 // Outputf prints the output directly
 func Outputf(format string, a ...interface{}) {
 	logAtLevel(Output, format, a...)
@@ -18,7 +18,7 @@ const (
 )
 
 var (
-	argNameRegex = regexp.MustCompile("^-[0-9A-Za-z]+$")
+	argNameRegex = regexp.MustCompile("^-[0-9A-Za-z][0-9A-Za-z\\-]*$")
 )
 
 // ArgSet is a set of arguments to be parsed
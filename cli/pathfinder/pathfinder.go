@@ -11,18 +11,20 @@ import (
 	"github.com/dimes/zbuild/model"
 )
 
-const (
-	compileResolver = "compile"
-	testResolver    = "test"
-)
-
 func main() {
 	argSet := argv.NewArgSet()
 	var path string
 	var resolver string
+	var listDependencies bool
+	var listEntries bool
+	var offline bool
 	argSet.ExpectString(&path, "path", "", "the file to get the path for")
-	argSet.ExpectString(&resolver, "resolver", compileResolver, "the type of dependency resolver to use")
+	argSet.ExpectString(&resolver, "resolver", "", "the type of dependency resolver to use")
+	argSet.ExpectBool(&listDependencies, "deps", false, "list the resolved dependencies instead of the path")
+	argSet.ExpectBool(&listEntries, "entries", false, "list the package and build of each path entry")
+	argSet.ExpectBool(&offline, "offline", false, "only use artifacts that are already downloaded")
 	argSet.Parse(os.Args[1:])
+	local.SetOffline(offline)
 
 	if path == "" {
 		workingDir, err := os.Getwd()
@@ -32,37 +34,75 @@ func main() {
 		path = workingDir
 	}
 
-	var dependencyResolver local.DependencyResolver
-	switch resolver {
-	case compileResolver:
-		dependencyResolver = local.CompileDependencyResolver
-	case testResolver:
-		dependencyResolver = local.TestDependencyResolver
-	default:
-		buildlog.Fatalf("Could not find dependency resolver of type %s", resolver)
-	}
-
 	workspace, err := local.GetWorkspace(path)
 	if err != nil {
 		buildlog.Fatalf("Error getting workspace for %s: %+v", path, err)
 	}
 
-	relativePath, err := filepath.Rel(workspace, path)
+	workspaceConfig, err := local.GetWorkspaceConfig(workspace)
+	if err != nil {
+		buildlog.Fatalf("Error getting workspace config: %+v", err)
+	}
+
+	if level, err := buildlog.ParseLogLevel(workspaceConfig.LogLevel); err == nil {
+		buildlog.SetLogLevel(level)
+	}
+
+	if resolver == "" {
+		resolver = workspaceConfig.GetDefaultResolverName()
+	}
+
+	dependencyResolver, err := local.GetDependencyResolver(resolver)
+	if err != nil {
+		buildlog.Fatalf("%+v", err)
+	}
+
+	packageLocation, err := local.GetPackageDir(path)
 	if err != nil {
-		buildlog.Fatalf("Error getting path %s relative to %s: %+v", path, workspace, err)
+		buildlog.Fatalf("Error finding the package containing %s: %+v", path, err)
 	}
 
-	packageLocation := filepath.Join(workspace, strings.Split(relativePath, string(os.PathSeparator))[0])
 	parsedBuildfile, err := model.ParseBuildfile(filepath.Join(packageLocation, model.BuildfileName))
 	if err != nil {
 		buildlog.Fatalf("Error parsing build file for package %s: %+v", packageLocation, err)
 	}
 
+	if listDependencies {
+		buildlog.Debugf("Getting dependencies for %s", path)
+		resolved, err := local.GetResolvedDependencies(workspace, parsedBuildfile.Package, dependencyResolver)
+		if err != nil {
+			buildlog.Fatalf("Error getting dependencies for %s: %+v", path, err)
+		}
+
+		for _, line := range local.DescribeDependencies(resolved) {
+			buildlog.Outputf("%s\n", line)
+		}
+		return
+	}
+
+	if listEntries {
+		buildlog.Debugf("Getting build path entries for %s", path)
+		entries, err := local.GetBuildpathEntries(workspace, parsedBuildfile.Package, dependencyResolver)
+		if err != nil {
+			buildlog.Fatalf("Error getting build path for %s: %+v", path, err)
+		}
+
+		for _, entry := range entries {
+			buildNumber := entry.Artifact.BuildNumber
+			if buildNumber == "" {
+				buildNumber = "-"
+			}
+			buildlog.Outputf("%s\t%s/%s/%s\t%s\t%s\n", entry.Location, entry.Artifact.Namespace,
+				entry.Artifact.Name, entry.Artifact.Version, buildNumber, entry.Origin)
+		}
+		return
+	}
+
 	buildlog.Debugf("Getting build path for %s", path)
 	buildpath, err := local.GetBuildpath(workspace, parsedBuildfile.Package, dependencyResolver)
 	if err != nil {
 		buildlog.Fatalf("Error getting build path for %s: %+v", path, err)
 	}
 
-	buildlog.Outputf(strings.Join(buildpath, string(os.PathListSeparator)))
+	buildlog.Outputf("%s", strings.Join(buildpath, string(os.PathListSeparator)))
 }
@@ -1,7 +1,13 @@
 package commands
 
 import (
+	"bytes"
+	"fmt"
+	"os"
 	"path/filepath"
+	"strconv"
+	"text/tabwriter"
+	"time"
 
 	"github.com/dimes/zbuild/local"
 
@@ -9,39 +15,491 @@ import (
 	"github.com/dimes/zbuild/builders/golang"
 	"github.com/dimes/zbuild/builders/protobuf"
 	"github.com/dimes/zbuild/buildlog"
+	"github.com/dimes/zbuild/cli/argv"
 	"github.com/dimes/zbuild/model"
+	"github.com/dimes/zbuild/sandbox"
+)
+
+const (
+	buildStatusOK        = "ok"
+	buildStatusUpToDate  = "up to date"
+	buildStatusCached    = "cached" // Restored from the build cache
+	buildStatusFailed    = "failed"
+	buildStatusSkipped   = "skipped"   // A dependency failed
+	buildStatusCancelled = "cancelled" // Another package failed without -keep-going
 )
 
 type build struct{}
 
+// buildOptions change how each package is built
+type buildOptions struct {
+	force    bool // Build packages even if their inputs haven't changed
+	hermetic bool // Build packages in a sandbox, even if the workspace doesn't require it
+}
+
+// buildResult is the outcome of a planned build
+type buildResult struct {
+	planned  *local.PlannedBuild
+	status   string
+	duration time.Duration
+	err      error
+}
+
 func (b *build) Describe() string {
-	return "Builds a package"
+	return "Builds a package, the given packages, or every package in the workspace with -all"
 }
 
 func (b *build) Exec(workingDir string, args ...string) error {
+	var all bool
+	var withDeps bool
+	var keepGoing bool
+	var force bool
+	var hermetic bool
+	var parallelism string
+	argSet := argv.NewArgSet()
+	argSet.ExpectBool(&all, "all", false, "build every package checked out in the workspace")
+	argSet.ExpectBool(&withDeps, "with-deps", false, "also build the workspace packages the packages depend on")
+	argSet.ExpectBool(&keepGoing, "keep-going", false, "keep building packages that don't depend on a failed one")
+	argSet.ExpectBool(&force, "force", false, "build packages even if their inputs haven't changed")
+	argSet.ExpectBool(&hermetic, "hermetic", false, "build packages in a sandbox with only their declared inputs")
+	argSet.ExpectString(&parallelism, "parallelism", "", "the number of packages built at once")
+	rest, err := argSet.Parse(args)
+	if err != nil {
+		return fmt.Errorf("Error parsing args: %+v", err)
+	}
+
+	options := &buildOptions{force: force, hermetic: hermetic}
+	registerBuilders()
+	local.StartBuildRun()
+	workspace, err := local.GetWorkspace(workingDir)
+	if err != nil {
+		return fmt.Errorf("Could not find workspace for %s: %+v", workingDir, err)
+	}
+
+	if !all && !withDeps && len(rest) == 0 {
+		parsedBuildfile, err := model.ParseBuildfile(filepath.Join(workingDir, model.BuildfileName))
+		if err != nil {
+			return fmt.Errorf("Error parsing buildfile: %+v", err)
+		}
+
+		_, err = buildPackage(workspace, parsedBuildfile, options)
+		return err
+	}
+
+	workers, err := getParallelism(workspace, parallelism)
+	if err != nil {
+		return err
+	}
+
+	targets, err := getBuildTargets(workingDir, workspace, all, rest)
+	if err != nil {
+		return err
+	}
+
+	plan, err := local.PlanWorkspaceBuild(workspace, targets, withDeps)
+	if err != nil {
+		return fmt.Errorf("Error planning build: %+v", err)
+	}
+
+	buildlog.Infof("Building %d packages with up to %d at once", len(plan), workers)
+	results := runPlannedBuilds(workspace, plan, workers, keepGoing, options)
+	printBuildSummary(workspace, results)
+
+	failed := 0
+	for _, result := range results {
+		if !isBuilt(result.status) {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d packages were not built", failed, len(results))
+	}
+
+	return nil
+}
+
+func registerBuilders() {
 	zbuild.RegisterBuilder(golang.NewBuilder())
 	zbuild.RegisterBuilder(protobuf.NewBuilder())
 	zbuild.RegisterBuilder(protobuf.NewProtogen())
+}
 
-	workspace, err := local.GetWorkspace(workingDir)
+// getParallelism returns the number of packages to build at once, which is the value of the
+// -parallelism flag if it was passed, or the workspace setting otherwise
+func getParallelism(workspace, parallelism string) (int, error) {
+	if parallelism != "" {
+		workers, err := strconv.Atoi(parallelism)
+		if err != nil || workers < 1 {
+			return 0, fmt.Errorf("Expected a positive number for -parallelism but got %s", parallelism)
+		}
+		return workers, nil
+	}
+
+	workspaceConfig, err := local.GetWorkspaceConfig(workspace)
 	if err != nil {
-		buildlog.Fatalf("Could not find workspace for %s: %+v", workingDir, err)
+		return 0, fmt.Errorf("Error reading workspace config: %+v", err)
 	}
 
-	parsedBuildfile, err := model.ParseBuildfile(filepath.Join(workingDir, model.BuildfileName))
+	return workspaceConfig.GetParallelism(), nil
+}
+
+// getBuildTargets returns every workspace package if all is set. Otherwise each argument is either a
+// package directory or the namespace/name of a workspace package. Without arguments, the package in
+// the working directory is the target
+func getBuildTargets(workingDir, workspace string, all bool, args []string) ([]*model.ParsedBuildfile, error) {
+	workspacePackages, err := local.GetWorkspacePackages(workspace)
 	if err != nil {
-		buildlog.Fatalf("Error parsing buildfile: %+v", err)
+		return nil, fmt.Errorf("Error listing workspace packages: %+v", err)
+	}
+
+	if all {
+		return workspacePackages, nil
+	}
+
+	if len(args) == 0 {
+		args = []string{"."}
 	}
 
+	targets := make([]*model.ParsedBuildfile, 0, len(args))
+	for _, arg := range args {
+		buildfileLocation := filepath.Join(workingDir, arg, model.BuildfileName)
+		if _, err := os.Stat(buildfileLocation); err == nil {
+			parsedBuildfile, err := model.ParseBuildfile(buildfileLocation)
+			if err != nil {
+				return nil, fmt.Errorf("Error parsing buildfile: %+v", err)
+			}
+			targets = append(targets, parsedBuildfile)
+			continue
+		}
+
+		var target *model.ParsedBuildfile
+		for _, parsedBuildfile := range workspacePackages {
+			if parsedBuildfile.Namespace+"/"+parsedBuildfile.Name != arg {
+				continue
+			}
+
+			if target != nil {
+				return nil, fmt.Errorf("%s is checked out more than once, in %s and %s. Pass a directory instead",
+					arg, target.AbsoluteWorkingDir, parsedBuildfile.AbsoluteWorkingDir)
+			}
+			target = parsedBuildfile
+		}
+
+		if target == nil {
+			return nil, fmt.Errorf("%s is neither a package directory nor the namespace/name of a workspace package",
+				arg)
+		}
+		targets = append(targets, target)
+	}
+
+	return targets, nil
+}
+
+// runPlannedBuilds builds the packages with up to workers builds at once. A package is only built once
+// every package it depends on was built. After a failure, no more builds are started unless keepGoing
+// is set. The results are in the order of the plan
+func runPlannedBuilds(workspace string, plan []*local.PlannedBuild, workers int,
+	keepGoing bool, options *buildOptions) []*buildResult {
+	results := make(map[*local.PlannedBuild]*buildResult)
+	started := make(map[*local.PlannedBuild]bool)
+	done := make(chan *buildResult)
+	running := 0
+	stopping := false
+
+	for len(results) < len(plan) {
+		for _, planned := range plan {
+			if started[planned] {
+				continue
+			}
+
+			if stopping {
+				started[planned] = true
+				results[planned] = &buildResult{planned: planned, status: buildStatusCancelled}
+				continue
+			}
+
+			if planned.Err != nil {
+				started[planned] = true
+				results[planned] = &buildResult{planned: planned, status: buildStatusFailed, err: planned.Err}
+				buildlog.Errorf("Error building %s: %+v", planned.Buildfile.Package.String(), planned.Err)
+				stopping = !keepGoing
+				continue
+			}
+
+			ready := true
+			for _, dependency := range planned.Dependencies {
+				if result := results[dependency]; result == nil {
+					ready = false
+				} else if !isBuilt(result.status) {
+					started[planned] = true
+					results[planned] = &buildResult{
+						planned: planned,
+						status:  buildStatusSkipped,
+						err:     fmt.Errorf("%s was not built", dependency.Buildfile.Package.String()),
+					}
+					ready = false
+					break
+				}
+			}
+
+			if !ready || running >= workers {
+				continue
+			}
+
+			started[planned] = true
+			running++
+			go func(planned *local.PlannedBuild) {
+				start := time.Now()
+				status, err := buildPackage(workspace, planned.Buildfile, options)
+				result := &buildResult{planned: planned, status: status, duration: time.Since(start)}
+				if err != nil {
+					result.status = buildStatusFailed
+					result.err = err
+				}
+				done <- result
+			}(planned)
+		}
+
+		// The plan is in dependency order, so a pass that starts nothing while nothing is running can't
+		// be followed by one that does. Wait for a running build to finish instead of polling
+		if running == 0 {
+			for _, planned := range plan {
+				if results[planned] == nil {
+					results[planned] = &buildResult{
+						planned: planned,
+						status:  buildStatusSkipped,
+						err:     fmt.Errorf("%s depends on packages that aren't planned", planned.Buildfile.Package.String()),
+					}
+				}
+			}
+			break
+		}
+
+		result := <-done
+		running--
+		results[result.planned] = result
+		if result.err != nil {
+			buildlog.Errorf("Error building %s: %+v", result.planned.Buildfile.Package.String(), result.err)
+			stopping = stopping || !keepGoing
+		}
+	}
+
+	ordered := make([]*buildResult, 0, len(plan))
+	for _, planned := range plan {
+		ordered = append(ordered, results[planned])
+	}
+	return ordered
+}
+
+// printBuildSummary prints a table with the status and duration of every planned build
+func printBuildSummary(workspace string, results []*buildResult) {
+	output := &bytes.Buffer{}
+	table := tabwriter.NewWriter(output, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(table, "PACKAGE\tLOCATION\tSTATUS\tDURATION\n")
+	for _, result := range results {
+		location, err := filepath.Rel(workspace, result.planned.Buildfile.AbsoluteWorkingDir)
+		if err != nil {
+			location = result.planned.Buildfile.AbsoluteWorkingDir
+		}
+
+		duration := "-"
+		if result.status == buildStatusOK || result.status == buildStatusCached ||
+			result.status == buildStatusFailed {
+			duration = result.duration.Round(time.Millisecond).String()
+		}
+
+		status := result.status
+		if result.status == buildStatusSkipped {
+			status = fmt.Sprintf("%s (%+v)", status, result.err)
+		}
+
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", result.planned.Buildfile.Package.String(), location,
+			status, duration)
+	}
+	table.Flush()
+
+	buildlog.Outputf("\n%s", output.String())
+}
+
+// isBuilt returns true if the status means the package's build output is ready to be used
+func isBuilt(status string) bool {
+	return status == buildStatusOK || status == buildStatusUpToDate || status == buildStatusCached
+}
+
+// buildPackage builds a single package with the builder for its type. The builder isn't run if the
+// package's inputs haven't changed since its last successful build, unless forced, or if the build
+// cache has the output of a build with the same inputs. Returns the status of the build
+func buildPackage(workspace string, parsedBuildfile *model.ParsedBuildfile, options *buildOptions) (string, error) {
 	buildlog.Infof("Parsed buildfile for %s", parsedBuildfile.Package.String())
 	builder := zbuild.GetBuilderForType(parsedBuildfile.Type)
 	if builder == nil {
-		buildlog.Fatalf("Could not find builder for type %s", parsedBuildfile.Type)
+		return "", fmt.Errorf("Could not find builder for type %s", parsedBuildfile.Type)
 	}
 
-	if err = builder.Build(workspace, parsedBuildfile); err != nil {
-		buildlog.Fatalf("Error during build: %+v", err)
+	fingerprint, err := local.ComputeFingerprint(workspace, parsedBuildfile,
+		fmt.Sprintf("%s@%s", builder.Type(), builder.Version()))
+	if err != nil {
+		return "", fmt.Errorf("Error computing fingerprint: %+v", err)
 	}
 
-	return nil
+	if options.force {
+		buildlog.Debugf("Rebuilding %s because -force was passed", parsedBuildfile.Package.String())
+	} else {
+		previous, err := local.GetFingerprint(workspace, parsedBuildfile)
+		if err != nil {
+			return "", err
+		}
+
+		changes := fingerprint.Changes(previous)
+		if len(changes) == 0 {
+			buildlog.Infof("%s is up to date", parsedBuildfile.Package.String())
+			return buildStatusUpToDate, nil
+		}
+
+		for _, change := range changes {
+			buildlog.Debugf("Rebuilding %s because %s", parsedBuildfile.Package.String(), change)
+		}
+	}
+
+	// The output of the last successful build is only replaced once this build succeeds
+	pendingBuild, err := local.StartBuild(workspace, parsedBuildfile)
+	if err != nil {
+		return "", err
+	}
+
+	committed := false
+	defer func() {
+		if committed {
+			return
+		}
+
+		if err := pendingBuild.Rollback(); err != nil {
+			buildlog.Warningf("Error restoring the last build of %s: %+v", parsedBuildfile.Package.String(), err)
+		}
+	}()
+
+	digest, err := fingerprint.Digest()
+	if err != nil {
+		return "", err
+	}
+
+	workspaceConfig, err := local.GetWorkspaceConfig(workspace)
+	if err != nil {
+		return "", fmt.Errorf("Error reading workspace config: %+v", err)
+	}
+	hermetic := options.hermetic || workspaceConfig.Hermetic
+
+	cache, err := local.GetBuildCache(workspace)
+	if err != nil {
+		return "", err
+	}
+
+	cacheKey := ""
+	if cache != nil {
+		cacheKey, err = zbuild.GetBuildCacheKey(builder, workspace, parsedBuildfile, digest, hermetic)
+		if err != nil {
+			return "", fmt.Errorf("Error computing build cache key: %+v", err)
+		}
+	}
+
+	if cache != nil && !options.force {
+		restored, err := local.RestoreBuild(cache, parsedBuildfile, cacheKey)
+		if err != nil {
+			return "", err
+		}
+
+		if restored {
+			buildlog.Infof("Restored %s from the build cache", parsedBuildfile.Package.String())
+			if err := pendingBuild.Commit(fingerprint); err != nil {
+				return "", err
+			}
+
+			committed = true
+			return buildStatusCached, nil
+		}
+	}
+
+	if hermetic {
+		err = buildHermetically(workspace, parsedBuildfile)
+	} else {
+		err = builder.Build(workspace, parsedBuildfile)
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("Error during build: %+v", err)
+	}
+
+	if err := pendingBuild.Commit(fingerprint); err != nil {
+		return "", err
+	}
+	committed = true
+
+	if cache != nil {
+		// The build succeeded, so failing to share its output isn't an error
+		if err := local.StoreBuild(cache, parsedBuildfile, cacheKey); err != nil {
+			buildlog.Warningf("Error storing %s in the build cache: %+v", parsedBuildfile.Package.String(), err)
+		}
+	}
+
+	return buildStatusOK, nil
+}
+
+// buildHermetically runs the build of the package in a sandbox. The sandboxed process is another
+// zbuild process, which runs the builder through BuildInSandbox
+func buildHermetically(workspace string, parsedBuildfile *model.ParsedBuildfile) error {
+	hermeticBuild, err := local.NewHermeticBuild(workspace, parsedBuildfile)
+	if err != nil {
+		return err
+	}
+	defer hermeticBuild.Close()
+
+	args := []string{"-offline"}
+	if buildlog.GetLogLevel() == buildlog.Debug {
+		args = append(args, "-v")
+	}
+
+	cmd, err := sandbox.Command(hermeticBuild.Spec, args...)
+	if err != nil {
+		return fmt.Errorf("Error creating sandbox: %+v", err)
+	}
+
+	buildlog.Debugf("Building %s in sandbox %s", parsedBuildfile.Package.String(), hermeticBuild.Spec.Root)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("Error building %s in sandbox: %+v", parsedBuildfile.Package.String(), err)
+	}
+
+	return hermeticBuild.CopyOutput()
+}
+
+// BuildInSandbox enters the sandbox the process was started in by buildHermetically and builds the
+// package in the working directory. Only the builder runs in the sandbox
+func BuildInSandbox() error {
+	if err := sandbox.Enter(); err != nil {
+		return err
+	}
+
+	workingDir, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("Error getting working directory: %+v", err)
+	}
+
+	workspace, err := local.GetWorkspace(workingDir)
+	if err != nil {
+		return fmt.Errorf("Could not find workspace for %s: %+v", workingDir, err)
+	}
+
+	parsedBuildfile, err := model.ParseBuildfile(filepath.Join(workingDir, model.BuildfileName))
+	if err != nil {
+		return fmt.Errorf("Error parsing buildfile: %+v", err)
+	}
+
+	registerBuilders()
+	local.StartBuildRun()
+	builder := zbuild.GetBuilderForType(parsedBuildfile.Type)
+	if builder == nil {
+		return fmt.Errorf("Could not find builder for type %s", parsedBuildfile.Type)
+	}
+
+	return builder.Build(workspace, parsedBuildfile)
 }
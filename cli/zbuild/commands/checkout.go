@@ -0,0 +1,50 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dimes/zbuild/buildlog"
+	"github.com/dimes/zbuild/cli/argv"
+	"github.com/dimes/zbuild/local"
+)
+
+type checkout struct{}
+
+func (c *checkout) Describe() string {
+	return "Clones the source of namespace/name[/version] into the workspace"
+}
+
+func (c *checkout) Exec(workingDir string, args ...string) error {
+	var destination string
+	argSet := argv.NewArgSet()
+	argSet.ExpectString(&destination, "dir", "", "the directory to clone into. Defaults to the package name")
+	rest, err := argSet.Parse(args)
+	if err != nil {
+		return fmt.Errorf("Error parsing args: %+v", err)
+	}
+
+	if len(rest) != 1 {
+		return fmt.Errorf("Expected a single namespace/name[/version] argument")
+	}
+
+	parts := strings.Split(rest[0], "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return fmt.Errorf("Expected namespace/name[/version] but got %s", rest[0])
+	}
+	parts = append(parts, "")
+	namespace, name, version := parts[0], parts[1], parts[2]
+
+	workspace, err := local.GetWorkspace(workingDir)
+	if err != nil {
+		return fmt.Errorf("Could not find workspace for %s: %+v", workingDir, err)
+	}
+
+	location, err := local.CheckoutPackage(workspace, namespace, name, version, destination)
+	if err != nil {
+		return fmt.Errorf("Error checking out %s: %+v", rest[0], err)
+	}
+
+	buildlog.Infof("Checked out %s/%s into %s", namespace, name, location)
+	return nil
+}
@@ -0,0 +1,44 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/dimes/zbuild/buildlog"
+	"github.com/dimes/zbuild/cli/argv"
+	"github.com/dimes/zbuild/local"
+)
+
+type clean struct{}
+
+func (c *clean) Describe() string {
+	return "Removes the build output of a package, the given packages, or every package with -all"
+}
+
+func (c *clean) Exec(workingDir string, args ...string) error {
+	var all bool
+	argSet := argv.NewArgSet()
+	argSet.ExpectBool(&all, "all", false, "clean every package checked out in the workspace")
+	rest, err := argSet.Parse(args)
+	if err != nil {
+		return fmt.Errorf("Error parsing args: %+v", err)
+	}
+
+	workspace, err := local.GetWorkspace(workingDir)
+	if err != nil {
+		return fmt.Errorf("Could not find workspace for %s: %+v", workingDir, err)
+	}
+
+	targets, err := getBuildTargets(workingDir, workspace, all, rest)
+	if err != nil {
+		return err
+	}
+
+	for _, target := range targets {
+		if err := local.CleanBuild(workspace, target); err != nil {
+			return fmt.Errorf("Error cleaning %s: %+v", target.Package.String(), err)
+		}
+		buildlog.Infof("Cleaned %s", target.Package.String())
+	}
+
+	return nil
+}
@@ -1,7 +1,10 @@
 package commands
 
 import (
+	"fmt"
+
 	"github.com/dimes/zbuild/buildlog"
+	"github.com/dimes/zbuild/local"
 
 	"github.com/manifoldco/promptui"
 )
@@ -10,14 +13,50 @@ var (
 	// Build is the command that executes a build
 	Build Command = &build{}
 
+	// Checkout clones a package's source into the workspace
+	Checkout Command = &checkout{}
+
+	// Clean removes build output
+	Clean Command = &clean{}
+
+	// Config reads and changes the workspace settings
+	Config Command = &config{}
+
+	// Deps lists the resolved dependencies of a package
+	Deps Command = &deps{}
+
+	// Env prints the environment a package is built with
+	Env Command = &env{}
+
+	// Graph prints the resolved dependency graph
+	Graph Command = &graph{}
+
 	// InitWorkspace is the command that initializes a workspace on the local file system
 	InitWorkspace Command = &initWorkspace{}
 
+	// LocalRepository lists and clears packages published to the user's local repository
+	LocalRepository Command = &localRepository{}
+
+	// Lock records the exact builds of a package's dependencies in its lock file
+	Lock Command = &lock{}
+
 	// Publish is the command that uploads an artifact
 	Publish Command = &publish{}
 
 	// Refresh refreshes the workspace metadata
 	Refresh Command = &refresh{}
+
+	// Status gives an overview of the workspace
+	Status Command = &status{}
+
+	// UseSourceSet switches the source sets of the workspace
+	UseSourceSet Command = &useSourceSet{}
+
+	// Watch rebuilds packages when their sources change
+	Watch Command = &watch{}
+
+	// Why explains how a package entered the dependency closure
+	Why Command = &why{}
 )
 
 // Command is an interface for commands
@@ -26,6 +65,20 @@ type Command interface {
 	Exec(workingDir string, args ...string) error
 }
 
+// getDependencyResolver returns the named resolver, or the workspace's default resolver if the name
+// is empty
+func getDependencyResolver(workingDir, name string) (local.DependencyResolver, error) {
+	if name == "" {
+		workspaceConfig, err := local.GetWorkspaceConfig(workingDir)
+		if err != nil {
+			return nil, fmt.Errorf("Error getting workspace config: %+v", err)
+		}
+		name = workspaceConfig.GetDefaultResolverName()
+	}
+
+	return local.GetDependencyResolver(name)
+}
+
 func readLineWithPrompt(label string, validate promptui.ValidateFunc, defaultVal string) string {
 	prompt := promptui.Prompt{
 		Label:    label,
@@ -0,0 +1,69 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/dimes/zbuild/buildlog"
+	"github.com/dimes/zbuild/local"
+)
+
+const (
+	configGet  = "get"
+	configSet  = "set"
+	configList = "list"
+)
+
+type config struct{}
+
+func (c *config) Describe() string {
+	return "Reads (get <key>, list) or changes (set <key> <value>) the workspace settings"
+}
+
+func (c *config) Exec(workingDir string, args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("Expected one of %s, %s or %s", configGet, configSet, configList)
+	}
+
+	workspaceConfig, err := local.GetWorkspaceConfig(workingDir)
+	if err != nil {
+		return fmt.Errorf("Error getting workspace config: %+v", err)
+	}
+
+	switch args[0] {
+	case configGet:
+		if len(args) != 2 {
+			return fmt.Errorf("Expected %s <key>", configGet)
+		}
+
+		value, err := workspaceConfig.Get(args[1])
+		if err != nil {
+			return err
+		}
+		buildlog.Outputf("%s\n", value)
+	case configSet:
+		if len(args) != 3 {
+			return fmt.Errorf("Expected %s <key> <value>", configSet)
+		}
+
+		if err := workspaceConfig.Set(args[1], args[2]); err != nil {
+			return err
+		}
+
+		if err := local.WriteWorkspaceConfig(workingDir, workspaceConfig); err != nil {
+			return fmt.Errorf("Error writing workspace config: %+v", err)
+		}
+	case configList:
+		for _, key := range local.GetConfigKeys() {
+			value, err := workspaceConfig.Get(key)
+			if err != nil {
+				return err
+			}
+			buildlog.Outputf("%s=%s\n", key, value)
+		}
+	default:
+		return fmt.Errorf("Unknown config command %s. Expected one of %s, %s or %s", args[0], configGet,
+			configSet, configList)
+	}
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package commands
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/dimes/zbuild/buildlog"
+	"github.com/dimes/zbuild/cli/argv"
+	"github.com/dimes/zbuild/local"
+	"github.com/dimes/zbuild/model"
+)
+
+type deps struct{}
+
+func (d *deps) Describe() string {
+	return "Lists the resolved dependencies of a package"
+}
+
+func (d *deps) Exec(workingDir string, args ...string) error {
+	var resolver string
+	argSet := argv.NewArgSet()
+	argSet.ExpectString(&resolver, "resolver", "", "the type of dependency resolver to use")
+	if _, err := argSet.Parse(args); err != nil {
+		return fmt.Errorf("Error parsing args: %+v", err)
+	}
+
+	dependencyResolver, err := getDependencyResolver(workingDir, resolver)
+	if err != nil {
+		return err
+	}
+
+	workspace, err := local.GetWorkspace(workingDir)
+	if err != nil {
+		return fmt.Errorf("Could not find workspace for %s: %+v", workingDir, err)
+	}
+
+	parsedBuildfile, err := model.ParseBuildfile(filepath.Join(workingDir, model.BuildfileName))
+	if err != nil {
+		return fmt.Errorf("Error parsing buildfile: %+v", err)
+	}
+
+	resolved, err := local.GetResolvedDependencies(workspace, parsedBuildfile.Package, dependencyResolver)
+	if err != nil {
+		return fmt.Errorf("Error resolving dependencies of %s: %+v", parsedBuildfile.Package.String(), err)
+	}
+
+	for _, line := range local.DescribeDependencies(resolved) {
+		buildlog.Outputf("%s\n", line)
+	}
+
+	return nil
+}
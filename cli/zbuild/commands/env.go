@@ -0,0 +1,72 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/dimes/zbuild"
+	"github.com/dimes/zbuild/buildlog"
+	"github.com/dimes/zbuild/cli/argv"
+	"github.com/dimes/zbuild/local"
+)
+
+type env struct{}
+
+func (e *env) Describe() string {
+	return "Prints the environment a package is built with"
+}
+
+func (e *env) Exec(workingDir string, args ...string) error {
+	var origin bool
+	argSet := argv.NewArgSet()
+	argSet.ExpectBool(&origin, "origin", false, "show where each variable comes from")
+	rest, err := argSet.Parse(args)
+	if err != nil {
+		return fmt.Errorf("Error parsing args: %+v", err)
+	}
+
+	if len(rest) > 1 {
+		return fmt.Errorf("Expected at most one package but got %d", len(rest))
+	}
+
+	workspace, err := local.GetWorkspace(workingDir)
+	if err != nil {
+		return fmt.Errorf("Could not find workspace for %s: %+v", workingDir, err)
+	}
+
+	targets, err := getBuildTargets(workingDir, workspace, false, rest)
+	if err != nil {
+		return err
+	}
+	parsedBuildfile := targets[0]
+
+	registerBuilders()
+	builder := zbuild.GetBuilderForType(parsedBuildfile.Type)
+	if builder == nil {
+		return fmt.Errorf("Could not find builder for type %s", parsedBuildfile.Type)
+	}
+
+	buildEnv, err := zbuild.GetBuildEnv(builder, workspace, parsedBuildfile)
+	if err != nil {
+		return fmt.Errorf("Error getting build environment of %s: %+v", parsedBuildfile.Package.String(), err)
+	}
+
+	if !origin {
+		for _, variable := range buildEnv.Environ() {
+			buildlog.Outputf("%s\n", variable)
+		}
+		return nil
+	}
+
+	output := &bytes.Buffer{}
+	table := tabwriter.NewWriter(output, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(table, "NAME\tORIGIN\tVALUE\n")
+	for _, envVar := range buildEnv.Vars() {
+		fmt.Fprintf(table, "%s\t%s\t%s\n", envVar.Name, envVar.Origin, envVar.Value)
+	}
+	table.Flush()
+
+	buildlog.Outputf("%s", output.String())
+	return nil
+}
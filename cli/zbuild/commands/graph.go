@@ -0,0 +1,86 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+
+	"github.com/dimes/zbuild/buildlog"
+	"github.com/dimes/zbuild/cli/argv"
+	"github.com/dimes/zbuild/local"
+	"github.com/dimes/zbuild/model"
+)
+
+const (
+	graphFormatDOT  = "dot"
+	graphFormatJSON = "json"
+)
+
+type graph struct{}
+
+func (g *graph) Describe() string {
+	return "Prints the resolved dependency graph of a package, or of the whole workspace with -all"
+}
+
+func (g *graph) Exec(workingDir string, args ...string) error {
+	var resolver string
+	var format string
+	var all bool
+	argSet := argv.NewArgSet()
+	argSet.ExpectString(&resolver, "resolver", "", "the type of dependency resolver to use")
+	argSet.ExpectString(&format, "format", graphFormatDOT, "the output format, dot or json")
+	argSet.ExpectBool(&all, "all", false, "include every package checked out in the workspace")
+	if _, err := argSet.Parse(args); err != nil {
+		return fmt.Errorf("Error parsing args: %+v", err)
+	}
+
+	if format != graphFormatDOT && format != graphFormatJSON {
+		return fmt.Errorf("Unknown format %s. Expected %s or %s", format, graphFormatDOT, graphFormatJSON)
+	}
+
+	dependencyResolver, err := getDependencyResolver(workingDir, resolver)
+	if err != nil {
+		return err
+	}
+
+	workspace, err := local.GetWorkspace(workingDir)
+	if err != nil {
+		return fmt.Errorf("Could not find workspace for %s: %+v", workingDir, err)
+	}
+
+	var roots []*model.ParsedBuildfile
+	if all {
+		if roots, err = local.GetWorkspacePackages(workspace); err != nil {
+			return fmt.Errorf("Error listing workspace packages: %+v", err)
+		}
+	} else {
+		parsedBuildfile, err := model.ParseBuildfile(filepath.Join(workingDir, model.BuildfileName))
+		if err != nil {
+			return fmt.Errorf("Error parsing buildfile: %+v", err)
+		}
+		roots = []*model.ParsedBuildfile{parsedBuildfile}
+	}
+
+	dependencyGraph := local.NewDependencyGraph()
+	for _, root := range roots {
+		resolved, err := local.GetResolvedDependencies(workspace, root.Package, dependencyResolver)
+		if err != nil {
+			return fmt.Errorf("Error resolving dependencies of %s: %+v", root.Package.String(), err)
+		}
+		dependencyGraph.Add(resolved)
+	}
+
+	output := &bytes.Buffer{}
+	if format == graphFormatJSON {
+		err = dependencyGraph.WriteJSON(output)
+	} else {
+		err = dependencyGraph.WriteDOT(output)
+	}
+
+	if err != nil {
+		return fmt.Errorf("Error writing graph: %+v", err)
+	}
+
+	buildlog.Outputf("%s", output.String())
+	return nil
+}
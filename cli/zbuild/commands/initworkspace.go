@@ -1,29 +1,42 @@
 package commands
 
 import (
-	"bufio"
 	"context"
 	"fmt"
+	"io/ioutil"
 	"os"
 
 	"github.com/dimes/zbuild/artifacts"
 	"github.com/dimes/zbuild/buildlog"
+	"github.com/dimes/zbuild/cli/argv"
 	"github.com/dimes/zbuild/local"
 
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/manifoldco/promptui"
+	"github.com/mattn/go-isatty"
 	"golang.org/x/sync/errgroup"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	backendNameAWS         = "aws"
+	backendNameGoogleCloud = "gcloud"
 )
 
 var (
 	backendTypeAWS         backendType = &awsBackendType{}
 	backendTypeGoogleCloud backendType = &gcloudBackendType{}
+
+	backendTypes = map[string]backendType{
+		backendNameAWS:         backendTypeAWS,
+		backendNameGoogleCloud: backendTypeGoogleCloud,
+	}
 )
 
 type backendType interface {
-	getManagerAndSourceSet(reader *bufio.Reader,
-		sourceSetName string) (artifacts.Manager, artifacts.SourceSet, error)
+	getManagerAndSourceSet(settings *settingReader,
+		config *initWorkspaceConfig) (artifacts.Manager, artifacts.SourceSet, error)
 }
 
 type awsBackendType struct{}
@@ -32,41 +45,118 @@ type gcloudBackendType struct{}
 
 type initWorkspace struct{}
 
+// initWorkspaceConfig contains every setting init-workspace needs. It can be read from a YAML or
+// JSON file, and each setting can also be passed as a flag of the same name
+type initWorkspaceConfig struct {
+	SourceSet       string `yaml:"sourceSet,omitempty"`
+	Backend         string `yaml:"backend,omitempty"` // aws or gcloud
+	Bucket          string `yaml:"bucket,omitempty"`
+	ArtifactTable   string `yaml:"artifactTable,omitempty"`
+	SourceSetTable  string `yaml:"sourceSetTable,omitempty"`
+	DependencyTable string `yaml:"dependencyTable,omitempty"`
+	Region          string `yaml:"region,omitempty"`
+	Profile         string `yaml:"profile,omitempty"`
+	CreateResources *bool  `yaml:"createResources,omitempty"`
+}
+
 func (i *initWorkspace) Describe() string {
 	return "Initializes a workspace"
 }
 
 func (i *initWorkspace) Exec(workingDir string, args ...string) error {
+	var configFile string
+	var join string
+	var createResources bool
+	flags := &initWorkspaceConfig{}
+	argSet := argv.NewArgSet()
+	argSet.ExpectString(&configFile, "config", "", "a YAML or JSON file containing the settings")
+	argSet.ExpectString(&join, "join", "", "a backend config file, or a workspace or its workspace.yaml, "+
+		"whose source set and manager should be reused")
+	argSet.ExpectString(&flags.SourceSet, "source-set", "", "the name of the source set")
+	argSet.ExpectString(&flags.Backend, "backend", "", "the backend type, aws or gcloud")
+	argSet.ExpectString(&flags.Bucket, "bucket", "", "the S3 bucket for artifact storage")
+	argSet.ExpectString(&flags.ArtifactTable, "artifact-table", "", "the Dynamo table for artifact storage")
+	argSet.ExpectString(&flags.SourceSetTable, "source-set-table", "", "the Dynamo table for source set metadata")
+	argSet.ExpectString(&flags.DependencyTable, "dependency-table", "", "the Dynamo table for dependency metadata")
+	argSet.ExpectString(&flags.Region, "region", "", "the AWS region")
+	argSet.ExpectString(&flags.Profile, "profile", "", "the AWS credentials profile")
+	argSet.ExpectBool(&createResources, "create-resources", false, "create the bucket and tables if needed")
+	if _, err := argSet.Parse(args); err != nil {
+		return fmt.Errorf("Error parsing args: %+v", err)
+	}
+
+	if createResources {
+		flags.CreateResources = &createResources
+	}
+
 	if workspaceDir, err := local.GetWorkspace(workingDir); err == nil {
 		return fmt.Errorf("Workspace already exists at %s", workspaceDir)
 	} else if err != local.ErrWorkspaceNotFound {
 		return fmt.Errorf("Error validating no existing workspace: %+v", err)
 	}
 
-	reader := bufio.NewReader(os.Stdin)
-	buildlog.Infof("Welcome to the zbuild")
-	sourceSetName := readLineWithPrompt("Source set name", artifacts.IsValidName, "")
-	backendType, err := getBackendTypeFromUser()
+	if join != "" {
+		if configFile != "" {
+			return fmt.Errorf("-join and -config can't be combined")
+		}
+		return joinWorkspace(workingDir, join, flags)
+	}
+
+	config := &initWorkspaceConfig{}
+	if configFile != "" {
+		if err := readInitWorkspaceConfig(configFile, config); err != nil {
+			return err
+		}
+	}
+	config.override(flags)
+
+	settings := newSettingReader()
+	if settings.interactive {
+		buildlog.Infof("Welcome to the zbuild")
+	}
+
+	return initWorkspaceFromConfig(workingDir, config, settings)
+}
+
+// initWorkspaceFromConfig initializes a workspace with the settings, reading the missing ones with
+// the setting reader. Resources are created if the settings ask for it
+func initWorkspaceFromConfig(workingDir string, config *initWorkspaceConfig, settings *settingReader) error {
+
+	if err := settings.read(&config.SourceSet, "source-set", "Source set name", artifacts.IsValidName,
+		"", true); err != nil {
+		return err
+	}
+
+	backendType, err := settings.readBackendType(config)
 	if err != nil {
 		return fmt.Errorf("Error getting backend type: %+v", err)
 	}
 
-	buildlog.Infof("Please provide some info about the resources you'd like to use.")
-	buildlog.Infof("If the resources don't exist, then they can be created for you.")
-	manager, sourceSet, err := backendType.getManagerAndSourceSet(reader, sourceSetName)
+	if settings.interactive {
+		buildlog.Infof("Please provide some info about the resources you'd like to use.")
+		buildlog.Infof("If the resources don't exist, then they can be created for you.")
+	}
+
+	manager, sourceSet, err := backendType.getManagerAndSourceSet(settings, config)
 	if err != nil {
 		return err
 	}
 
-	if ok, err := getYnConfirmation("Create resources"); ok {
+	if config.CreateResources == nil && settings.interactive {
+		ok, err := getYnConfirmation("Create resources")
+		if err != nil {
+			return fmt.Errorf("Error getting confirmation for resource creation: %+v", err)
+		}
+		config.CreateResources = &ok
+	}
+
+	if config.CreateResources != nil && *config.CreateResources {
 		group, _ := errgroup.WithContext(context.Background())
 		group.Go(manager.Setup)
 		group.Go(sourceSet.Setup)
 		if err := group.Wait(); err != nil {
 			return fmt.Errorf("Error creating manager and source set: %+v", err)
 		}
-	} else if err != nil {
-		return fmt.Errorf("Error getting confirmation for resource creation: %+v", err)
 	}
 
 	if err = local.InitWorkspace(workingDir, sourceSet, manager); err != nil {
@@ -76,6 +166,169 @@ func (i *initWorkspace) Exec(workingDir string, args ...string) error {
 	return nil
 }
 
+// joinWorkspace initializes a workspace that uses the same source set and manager as a teammate's.
+// The path is either a backend config file in the format of -config, which can be committed or sent
+// around, or an existing workspace directory or its .workspace/workspace.yaml. Settings passed as
+// flags override the ones in a backend config file. Nothing is prompted for and resources are never
+// created, since they must already exist
+func joinWorkspace(workingDir, path string, flags *initWorkspaceConfig) error {
+	if flags.CreateResources != nil && *flags.CreateResources {
+		return fmt.Errorf("-join never creates resources, since the joined workspace already uses them")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("Error reading %s: %+v", path, err)
+	}
+
+	if info.IsDir() {
+		return joinExistingWorkspace(workingDir, path)
+	}
+
+	if workspace, ok := local.GetWorkspaceOfConfigFile(path); ok {
+		return joinExistingWorkspace(workingDir, workspace)
+	}
+
+	config := &initWorkspaceConfig{}
+	if err := readInitWorkspaceConfig(path, config); err != nil {
+		return err
+	}
+	config.override(flags)
+
+	if config.CreateResources != nil && *config.CreateResources {
+		buildlog.Infof("Ignoring createResources in %s, since -join never creates resources", path)
+	}
+	createResources := false
+	config.CreateResources = &createResources
+
+	buildlog.Infof("Joining source set %s", config.SourceSet)
+	return initWorkspaceFromConfig(workingDir, config, &settingReader{})
+}
+
+// joinExistingWorkspace initializes a workspace that uses the same source set, source set layers and
+// manager as the workspace containing the existing directory
+func joinExistingWorkspace(workingDir, existing string) error {
+	manager, err := local.GetRemoteManager(existing)
+	if err != nil {
+		return fmt.Errorf("Error getting the manager of %s: %+v", existing, err)
+	}
+
+	sourceSet, err := local.GetRemoteSourceSet(existing)
+	if err != nil {
+		return fmt.Errorf("Error getting the source set of %s: %+v", existing, err)
+	}
+
+	layers, err := local.GetRemoteSourceSetLayers(existing)
+	if err != nil {
+		return fmt.Errorf("Error getting the source set layers of %s: %+v", existing, err)
+	}
+
+	buildlog.Infof("Joining source set %s", sourceSet.Name())
+	if err = local.InitWorkspace(workingDir, sourceSet, manager, layers...); err != nil {
+		return fmt.Errorf("Error initializing workspace: %+v", err)
+	}
+
+	return nil
+}
+
+func readInitWorkspaceConfig(configFile string, config *initWorkspaceConfig) error {
+	configBytes, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return fmt.Errorf("Error reading %s: %+v", configFile, err)
+	}
+
+	// JSON is valid YAML, so both formats are parsed the same way
+	if err := yaml.UnmarshalStrict(configBytes, config); err != nil {
+		return fmt.Errorf("Error parsing %s: %+v", configFile, err)
+	}
+
+	return nil
+}
+
+// override replaces the settings with the ones set in other
+func (c *initWorkspaceConfig) override(other *initWorkspaceConfig) {
+	for _, setting := range []struct {
+		value    *string
+		override string
+	}{
+		{&c.SourceSet, other.SourceSet},
+		{&c.Backend, other.Backend},
+		{&c.Bucket, other.Bucket},
+		{&c.ArtifactTable, other.ArtifactTable},
+		{&c.SourceSetTable, other.SourceSetTable},
+		{&c.DependencyTable, other.DependencyTable},
+		{&c.Region, other.Region},
+		{&c.Profile, other.Profile},
+	} {
+		if setting.override != "" {
+			*setting.value = setting.override
+		}
+	}
+
+	if other.CreateResources != nil {
+		c.CreateResources = other.CreateResources
+	}
+}
+
+// settingReader fills in settings that weren't passed as flags or in a config file. The user is
+// only prompted if a terminal is attached. Otherwise, the default values are used
+type settingReader struct {
+	interactive bool
+	prompted    bool
+}
+
+func newSettingReader() *settingReader {
+	return &settingReader{
+		interactive: isatty.IsTerminal(os.Stdin.Fd()) || isatty.IsCygwinTerminal(os.Stdin.Fd()),
+	}
+}
+
+// read validates the value if it is set, and otherwise prompts for it or uses the default value.
+// Required settings without a default value fail when there is no terminal to prompt on
+func (s *settingReader) read(value *string, flag, label string, validate promptui.ValidateFunc,
+	defaultVal string, required bool) error {
+	if *value != "" {
+		if err := validate(*value); err != nil {
+			return fmt.Errorf("Invalid %s: %+v", flag, err)
+		}
+		return nil
+	}
+
+	if s.interactive {
+		*value = readLineWithPrompt(label, validate, defaultVal)
+		s.prompted = true
+		return nil
+	}
+
+	if required && defaultVal == "" {
+		return fmt.Errorf("%s is required. Pass it with -%s or in the config file", flag, flag)
+	}
+
+	*value = defaultVal
+	return nil
+}
+
+// readBackendType returns the configured backend type. AWS is used if there is no terminal to
+// prompt on
+func (s *settingReader) readBackendType(config *initWorkspaceConfig) (backendType, error) {
+	if config.Backend == "" && s.interactive {
+		s.prompted = true
+		return getBackendTypeFromUser()
+	}
+
+	if config.Backend == "" {
+		config.Backend = backendNameAWS
+	}
+
+	backendType, ok := backendTypes[config.Backend]
+	if !ok {
+		return nil, fmt.Errorf("Unknown backend %s. Expected %s or %s", config.Backend, backendNameAWS,
+			backendNameGoogleCloud)
+	}
+
+	return backendType, nil
+}
+
 func getBackendTypeFromUser() (backendType, error) {
 	type backendOption struct {
 		name        string
@@ -111,47 +364,65 @@ func getBackendTypeFromUser() (backendType, error) {
 	return options[selectedIndex].backendType, nil
 }
 
-func (a *awsBackendType) getManagerAndSourceSet(reader *bufio.Reader,
-	sourceSetName string) (artifacts.Manager, artifacts.SourceSet, error) {
-	bucketName := readLineWithPrompt("S3 bucket for artifact storage", artifacts.IsValidName, "")
-	artifactTableName := readLineWithPrompt("Dynamo table name for artifact storage", artifacts.IsValidName,
-		"zbuild-artifact-metadata")
-	sourceSetTableName := readLineWithPrompt("Dynamo table name for source set metadata",
-		artifacts.IsValidName, "zbuild-source-set-metadata")
-	dependencyTableName := readLineWithPrompt("Dynamo table name for dependency metadata",
-		artifacts.IsValidName, "zbuild-dependency-metadata")
-	region := readLineWithPrompt("AWS Region", artifacts.IsValidName, "us-east-1")
-	profile := readLineWithPrompt("(Optional) AWS credentials profile",
-		func(input string) error {
-			if input == "" {
-				return nil
-			}
-			return artifacts.IsValidName(input)
-		}, "")
+func (a *awsBackendType) getManagerAndSourceSet(settings *settingReader,
+	config *initWorkspaceConfig) (artifacts.Manager, artifacts.SourceSet, error) {
+	optional := func(input string) error {
+		if input == "" {
+			return nil
+		}
+		return artifacts.IsValidName(input)
+	}
+
+	for _, setting := range []struct {
+		value      *string
+		flag       string
+		label      string
+		validate   promptui.ValidateFunc
+		defaultVal string
+	}{
+		{&config.Bucket, "bucket", "S3 bucket for artifact storage", artifacts.IsValidName, ""},
+		{&config.ArtifactTable, "artifact-table", "Dynamo table name for artifact storage",
+			artifacts.IsValidName, "zbuild-artifact-metadata"},
+		{&config.SourceSetTable, "source-set-table", "Dynamo table name for source set metadata",
+			artifacts.IsValidName, "zbuild-source-set-metadata"},
+		{&config.DependencyTable, "dependency-table", "Dynamo table name for dependency metadata",
+			artifacts.IsValidName, "zbuild-dependency-metadata"},
+		{&config.Region, "region", "AWS Region", artifacts.IsValidName, "us-east-1"},
+		{&config.Profile, "profile", "(Optional) AWS credentials profile", optional, ""},
+	} {
+		required := setting.flag != "profile"
+		if err := settings.read(setting.value, setting.flag, setting.label, setting.validate,
+			setting.defaultVal, required); err != nil {
+			return nil, nil, err
+		}
+	}
+
 	buildlog.Infof(`
-		
+
 			S3 Bucket: %s
 			Artifact Table: %s
 			Source Set Table: %s
 			Region: %s
 			AWS Profile: %s
-			
-			`, bucketName, artifactTableName, sourceSetTableName, region, profile)
-	if ok, err := getYnConfirmation("Is this correct"); !ok || err != nil {
-		return nil, nil, fmt.Errorf("User must re-enter information")
+
+			`, config.Bucket, config.ArtifactTable, config.SourceSetTable, config.Region, config.Profile)
+	if settings.prompted {
+		if ok, err := getYnConfirmation("Is this correct"); !ok || err != nil {
+			return nil, nil, fmt.Errorf("User must re-enter information")
+		}
 	}
 
-	sess := local.NewSession(region, profile)
+	sess := local.NewSession(config.Region, config.Profile)
 	s3Svc := s3.New(sess)
 
-	manager, err := artifacts.NewS3Manager(s3Svc, bucketName, region, profile)
+	manager, err := artifacts.NewS3Manager(s3Svc, config.Bucket, config.Region, config.Profile)
 	if err != nil {
 		return nil, nil, err
 	}
 
 	dynamoSvc := dynamodb.New(sess)
-	sourceSet, err := artifacts.NewDynamoSourceSet(dynamoSvc, sourceSetName, sourceSetTableName,
-		artifactTableName, dependencyTableName, profile)
+	sourceSet, err := artifacts.NewDynamoSourceSet(dynamoSvc, config.SourceSet, config.SourceSetTable,
+		config.ArtifactTable, config.DependencyTable, config.Profile)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -159,7 +430,7 @@ func (a *awsBackendType) getManagerAndSourceSet(reader *bufio.Reader,
 	return manager, sourceSet, err
 }
 
-func (a *gcloudBackendType) getManagerAndSourceSet(reader *bufio.Reader,
-	sourceSetName string) (artifacts.Manager, artifacts.SourceSet, error) {
+func (a *gcloudBackendType) getManagerAndSourceSet(settings *settingReader,
+	config *initWorkspaceConfig) (artifacts.Manager, artifacts.SourceSet, error) {
 	return nil, nil, fmt.Errorf("Sorry! Google Cloud support is coming soon")
 }
@@ -0,0 +1,68 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dimes/zbuild/buildlog"
+	"github.com/dimes/zbuild/local"
+)
+
+const (
+	localRepositoryList  = "list"
+	localRepositoryClear = "clear"
+)
+
+type localRepository struct{}
+
+func (l *localRepository) Describe() string {
+	return "Lists (list) or removes (clear [namespace[/name[/version]]]) packages published with -local"
+}
+
+func (l *localRepository) Exec(workingDir string, args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("Expected one of %s or %s", localRepositoryList, localRepositoryClear)
+	}
+
+	switch args[0] {
+	case localRepositoryList:
+		localPublishes, err := local.GetLocalPublishes()
+		if err != nil {
+			return fmt.Errorf("Error listing local publishes: %+v", err)
+		}
+
+		if len(localPublishes) == 0 {
+			buildlog.Infof("No packages have been published locally")
+		}
+
+		for _, artifact := range localPublishes {
+			buildlog.Outputf("%s/%s/%s\t%s\n", artifact.Namespace, artifact.Name, artifact.Version,
+				artifact.BuildNumber)
+		}
+	case localRepositoryClear:
+		var namespace, name, version string
+		if len(args) > 1 {
+			parts := strings.Split(args[1], "/")
+			if len(parts) > 3 {
+				return fmt.Errorf("Expected namespace[/name[/version]] but got %s", args[1])
+			}
+
+			parts = append(parts, "", "")
+			namespace, name, version = parts[0], parts[1], parts[2]
+		}
+
+		removed, err := local.ClearLocalPublishes(namespace, name, version)
+		if err != nil {
+			return fmt.Errorf("Error clearing local publishes: %+v", err)
+		}
+
+		for _, artifact := range removed {
+			buildlog.Infof("Removed %s build %s", artifact.String(), artifact.BuildNumber)
+		}
+	default:
+		return fmt.Errorf("Unknown subcommand %s. Expected one of %s or %s", args[0],
+			localRepositoryList, localRepositoryClear)
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package commands
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/dimes/zbuild/buildlog"
+	"github.com/dimes/zbuild/cli/argv"
+	"github.com/dimes/zbuild/local"
+	"github.com/dimes/zbuild/model"
+)
+
+type lock struct{}
+
+func (l *lock) Describe() string {
+	return "Records the exact builds of a package's dependencies in " + model.LockfileName
+}
+
+func (l *lock) Exec(workingDir string, args ...string) error {
+	var update bool
+	var verify bool
+	argSet := argv.NewArgSet()
+	argSet.ExpectBool(&update, "update", false, "re-resolve every dependency instead of keeping locked builds")
+	argSet.ExpectBool(&verify, "verify", false, "fail if the lock file differs from the source set")
+	if _, err := argSet.Parse(args); err != nil {
+		return fmt.Errorf("Error parsing args: %+v", err)
+	}
+
+	workspace, err := local.GetWorkspace(workingDir)
+	if err != nil {
+		return fmt.Errorf("Could not find workspace for %s: %+v", workingDir, err)
+	}
+
+	parsedBuildfile, err := model.ParseBuildfile(filepath.Join(workingDir, model.BuildfileName))
+	if err != nil {
+		return fmt.Errorf("Error parsing buildfile: %+v", err)
+	}
+
+	if verify {
+		differences, err := local.VerifyLockfile(workspace, parsedBuildfile.Package)
+		if err != nil {
+			return fmt.Errorf("Error verifying %s: %+v", model.LockfileName, err)
+		}
+
+		for _, difference := range differences {
+			buildlog.Errorf("%s", difference)
+		}
+
+		if len(differences) > 0 {
+			return fmt.Errorf("%s is out of date. Run zbuild lock -update to update it", model.LockfileName)
+		}
+
+		buildlog.Infof("%s is up to date", model.LockfileName)
+		return nil
+	}
+
+	lockfile, err := local.LockDependencies(workspace, parsedBuildfile.Package, update)
+	if err != nil {
+		return fmt.Errorf("Error locking dependencies of %s: %+v", parsedBuildfile.Package.String(), err)
+	}
+
+	if err := lockfile.Write(parsedBuildfile.AbsoluteWorkingDir); err != nil {
+		return err
+	}
+
+	buildlog.Infof("Locked %d artifacts in %s", len(lockfile.Artifacts), model.LockfileName)
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/dimes/zbuild/artifacts"
 	"github.com/dimes/zbuild/buildlog"
+	"github.com/dimes/zbuild/cli/argv"
 	"github.com/dimes/zbuild/local"
 	"github.com/dimes/zbuild/model"
 )
@@ -18,6 +19,14 @@ func (p *publish) Describe() string {
 }
 
 func (p *publish) Exec(workingDir string, args ...string) error {
+	var localOnly bool
+	argSet := argv.NewArgSet()
+	argSet.ExpectBool(&localOnly, "local", false,
+		"publish to the user's local repository instead of the source set")
+	if _, err := argSet.Parse(args); err != nil {
+		return fmt.Errorf("Error parsing args: %+v", err)
+	}
+
 	parsedBuildfile, err := model.ParseBuildfile(filepath.Join(workingDir, model.BuildfileName))
 	if err != nil {
 		buildlog.Fatalf("Error parsing buildfile: %+v", err)
@@ -29,22 +38,44 @@ func (p *publish) Exec(workingDir string, args ...string) error {
 		return fmt.Errorf("Error getting local manager for %s: %+v", workingDir, err)
 	}
 
-	remoteManager, err := local.GetRemoteManager(workingDir)
-	if err != nil {
-		return fmt.Errorf("Error getting remote manager for %s: %+v", workingDir, err)
+	var remoteManager artifacts.Manager
+	var remoteSourceSet artifacts.SourceSet
+	if localOnly {
+		if remoteManager, err = local.GetRepositoryManager(); err != nil {
+			return fmt.Errorf("Error getting local repository manager: %+v", err)
+		}
+
+		if remoteSourceSet, err = local.GetRepositorySourceSet(); err != nil {
+			return fmt.Errorf("Error getting local repository source set: %+v", err)
+		}
+	} else {
+		if remoteManager, err = local.GetRemoteManager(workingDir); err != nil {
+			return fmt.Errorf("Error getting remote manager for %s: %+v", workingDir, err)
+		}
+
+		if remoteSourceSet, err = local.GetRemoteSourceSet(workingDir); err != nil {
+			return fmt.Errorf("Error getting remote source set for %s: %+v", workingDir, err)
+		}
 	}
 
 	buildNumber := fmt.Sprintf("%d", time.Now().Unix())
 	artifact := model.NewArtifact(parsedBuildfile.Package, buildNumber)
 
-	if err := artifacts.Transfer(localManager, remoteManager, artifact); err != nil {
-		return fmt.Errorf("Error transfering %s: %+v", artifact.String(), err)
+	if commit, dirty, err := local.GetCommit(workingDir); err != nil {
+		buildlog.Debugf("Not recording a commit for %s: %+v", artifact.String(), err)
+	} else {
+		if dirty {
+			buildlog.Warningf("%s has uncommitted changes, which are not part of commit %s",
+				parsedBuildfile.AbsoluteWorkingDir, commit)
+		}
+		artifact.Commit = commit
 	}
 
-	remoteSourceSet, err := local.GetRemoteSourceSet(workingDir)
+	digest, err := artifacts.TransferWithDigest(localManager, remoteManager, artifact)
 	if err != nil {
-		return fmt.Errorf("Error getting remote source set for %s: %+v", workingDir, err)
+		return fmt.Errorf("Error transfering %s: %+v", artifact.String(), err)
 	}
+	artifact.Digest = digest
 
 	if err := remoteSourceSet.RegisterArtifact(artifact); err != nil {
 		return fmt.Errorf("Error registering artifact: %+v", err)
@@ -54,5 +85,9 @@ func (p *publish) Exec(workingDir string, args ...string) error {
 		return fmt.Errorf("Error using artifact in source set: %+v", err)
 	}
 
+	if localOnly {
+		buildlog.Infof("Published %s build %s to the local repository", artifact.String(), buildNumber)
+	}
+
 	return nil
 }
@@ -23,7 +23,12 @@ func (r *refresh) Exec(workingDir string, args ...string) error {
 		return fmt.Errorf("Error getting remote source set for %s: %+v", workspaceDir, err)
 	}
 
-	if err := local.RefreshWorkspace(workspaceDir, remoteSourceSet); err != nil {
+	layers, err := local.GetRemoteSourceSetLayers(workspaceDir)
+	if err != nil {
+		return fmt.Errorf("Error getting source set layers for %s: %+v", workspaceDir, err)
+	}
+
+	if err := local.RefreshWorkspace(workspaceDir, remoteSourceSet, layers...); err != nil {
 		return fmt.Errorf("Error refreshing workspace metadata for %s: %+v", workspaceDir, err)
 	}
 
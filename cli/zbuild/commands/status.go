@@ -0,0 +1,99 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dimes/zbuild/buildlog"
+	"github.com/dimes/zbuild/local"
+)
+
+type status struct{}
+
+func (s *status) Describe() string {
+	return "Shows the workspace packages, their build state, source set changes and missing artifacts"
+}
+
+func (s *status) Exec(workingDir string, args ...string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("Unexpected arguments %v", args)
+	}
+
+	workspace, err := local.GetWorkspace(workingDir)
+	if err != nil {
+		return fmt.Errorf("Could not find workspace for %s: %+v", workingDir, err)
+	}
+
+	workspaceStatus, err := local.GetWorkspaceStatus(workspace)
+	if err != nil {
+		return fmt.Errorf("Error getting status of %s: %+v", workspace, err)
+	}
+
+	buildlog.Outputf("Workspace packages:\n")
+	if len(workspaceStatus.Packages) == 0 {
+		buildlog.Outputf("  none\n")
+	} else {
+		printPackageStatuses(workspace, workspaceStatus.Packages)
+	}
+
+	if !workspaceStatus.ChangesChecked {
+		buildlog.Outputf("\nOffline, not checking the source set for changes since the last refresh\n")
+	} else if workspaceStatus.ChangesError != nil {
+		buildlog.Outputf("\nCould not check the source set for changes: %+v\n", workspaceStatus.ChangesError)
+	} else if len(workspaceStatus.Changes) == 0 {
+		buildlog.Outputf("\nThe source set hasn't changed since the last refresh\n")
+	} else {
+		buildlog.Outputf("\nSource set changes since the last refresh (run zbuild refresh to apply them):\n")
+		for _, change := range workspaceStatus.Changes {
+			buildlog.Outputf("  %s\n", change.String())
+		}
+	}
+
+	if len(workspaceStatus.Missing) == 0 {
+		buildlog.Outputf("\nEvery dependency is in the package cache\n")
+	} else {
+		buildlog.Outputf("\nDependencies missing from the package cache:\n")
+		for _, artifact := range workspaceStatus.Missing {
+			buildlog.Outputf("  %s build %s\n", artifact.String(), artifact.BuildNumber)
+		}
+	}
+
+	return nil
+}
+
+// printPackageStatuses prints a table with the source set build and build state of every workspace
+// package, followed by the errors resolving their dependencies
+func printPackageStatuses(workspace string, packageStatuses []*local.PackageStatus) {
+	output := &bytes.Buffer{}
+	table := tabwriter.NewWriter(output, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(table, "  PACKAGE\tLOCATION\tSOURCE SET\tBUILD\n")
+	for _, packageStatus := range packageStatuses {
+		location, err := filepath.Rel(workspace, packageStatus.Buildfile.AbsoluteWorkingDir)
+		if err != nil {
+			location = packageStatus.Buildfile.AbsoluteWorkingDir
+		}
+
+		sourceSet := "not in source set"
+		if artifact := packageStatus.SourceSetArtifact; artifact != nil {
+			sourceSet = "build " + artifact.BuildNumber
+		} else if len(packageStatus.SourceSetVersions) > 0 {
+			sourceSet = fmt.Sprintf("has %s", strings.Join(packageStatus.SourceSetVersions, ", "))
+		}
+
+		fmt.Fprintf(table, "  %s\t%s\t%s\t%s\n", packageStatus.Buildfile.Package.String(), location,
+			sourceSet, packageStatus.Build)
+	}
+	table.Flush()
+
+	for _, packageStatus := range packageStatuses {
+		if packageStatus.ResolveError != nil {
+			fmt.Fprintf(output, "\nError resolving the dependencies of %s: %+v\n",
+				packageStatus.Buildfile.Package.String(), packageStatus.ResolveError)
+		}
+	}
+
+	buildlog.Outputf("%s", output.String())
+}
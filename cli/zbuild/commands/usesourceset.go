@@ -0,0 +1,60 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/dimes/zbuild/artifacts"
+	"github.com/dimes/zbuild/buildlog"
+	"github.com/dimes/zbuild/local"
+)
+
+type useSourceSet struct{}
+
+func (u *useSourceSet) Describe() string {
+	return "Points the workspace at a source set, optionally layered over lower source sets"
+}
+
+func (u *useSourceSet) Exec(workingDir string, args ...string) error {
+	workspace, err := local.GetWorkspace(workingDir)
+	if err != nil {
+		return fmt.Errorf("Could not find workspace for %s: %+v", workingDir, err)
+	}
+
+	if len(args) == 0 {
+		workspaceMetadata, err := local.GetWorkspaceMetadata(workspace)
+		if err != nil {
+			return fmt.Errorf("Error getting workspace metadata for %s: %+v", workspace, err)
+		}
+
+		buildlog.Outputf("%s\n", workspaceMetadata.SourceSetName)
+		for _, layer := range workspaceMetadata.SourceSetLayers {
+			buildlog.Outputf("%s\n", layer)
+		}
+		return nil
+	}
+
+	sourceSets := make([]artifacts.SourceSet, 0, len(args))
+	seen := make(map[string]bool)
+	for _, name := range args {
+		if seen[name] {
+			return fmt.Errorf("Source set %s is listed more than once", name)
+		}
+		seen[name] = true
+
+		sourceSet, err := local.GetNamedRemoteSourceSet(workspace, name)
+		if err != nil {
+			return fmt.Errorf("Error getting source set %s: %+v", name, err)
+		}
+		sourceSets = append(sourceSets, sourceSet)
+	}
+
+	if err := local.RefreshWorkspace(workspace, sourceSets[0], sourceSets[1:]...); err != nil {
+		return fmt.Errorf("Error refreshing workspace metadata for %s: %+v", workspace, err)
+	}
+
+	buildlog.Infof("Using source set %s", sourceSets[0].Name())
+	for _, layer := range sourceSets[1:] {
+		buildlog.Infof("Layered over %s", layer.Name())
+	}
+	return nil
+}
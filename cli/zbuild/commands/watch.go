@@ -0,0 +1,186 @@
+package commands
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dimes/zbuild/buildlog"
+	"github.com/dimes/zbuild/cli/argv"
+	"github.com/dimes/zbuild/local"
+)
+
+type watch struct{}
+
+func (w *watch) Describe() string {
+	return "Rebuilds workspace packages, and the packages that depend on them, whenever their sources change"
+}
+
+func (w *watch) Exec(workingDir string, args ...string) error {
+	var interval string
+	var debounce string
+	var parallelism string
+	argSet := argv.NewArgSet()
+	argSet.ExpectString(&interval, "interval", "1s", "how often sources are checked for changes")
+	argSet.ExpectString(&debounce, "debounce", "500ms", "how long sources must stay unchanged before building")
+	argSet.ExpectString(&parallelism, "parallelism", "", "the number of packages built at once")
+	if _, err := argSet.Parse(args); err != nil {
+		return fmt.Errorf("Error parsing args: %+v", err)
+	}
+
+	pollInterval, err := time.ParseDuration(interval)
+	if err != nil || pollInterval <= 0 {
+		return fmt.Errorf("Expected a positive duration for -interval but got %s", interval)
+	}
+
+	debounceDuration, err := time.ParseDuration(debounce)
+	if err != nil || debounceDuration < 0 {
+		return fmt.Errorf("Expected a duration for -debounce but got %s", debounce)
+	}
+
+	registerBuilders()
+
+	workspace, err := local.GetWorkspace(workingDir)
+	if err != nil {
+		return fmt.Errorf("Could not find workspace for %s: %+v", workingDir, err)
+	}
+
+	workers, err := getParallelism(workspace, parallelism)
+	if err != nil {
+		return err
+	}
+
+	// Every package is built once up front. Packages that are up to date are skipped by their
+	// fingerprints, so this is cheap
+	states, err := getSourceStates(workspace)
+	if err != nil {
+		return err
+	}
+
+	changed := make(map[string]bool)
+	for location := range states {
+		changed[location] = true
+	}
+
+	for {
+		// Each round resolves dependencies again, since build files may have changed
+		local.StartBuildRun()
+		plan, err := planWatchedBuild(workspace)
+		if err != nil {
+			return err
+		}
+
+		results := runPlannedBuilds(workspace, local.SelectDownstream(plan, changed), workers, true, &buildOptions{})
+		printWatchResults(results)
+		buildlog.Infof("Watching %d packages for changes", len(plan))
+
+		changed, states, err = waitForChanges(workspace, states, pollInterval, debounceDuration)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// planWatchedBuild plans a build of every workspace package
+func planWatchedBuild(workspace string) ([]*local.PlannedBuild, error) {
+	workspacePackages, err := local.GetWorkspacePackages(workspace)
+	if err != nil {
+		return nil, fmt.Errorf("Error listing workspace packages: %+v", err)
+	}
+
+	plan, err := local.PlanWorkspaceBuild(workspace, workspacePackages, false)
+	if err != nil {
+		return nil, fmt.Errorf("Error planning build: %+v", err)
+	}
+
+	return plan, nil
+}
+
+// waitForChanges polls the workspace packages until their sources differ from the given states and
+// then stay unchanged for the debounce duration. It returns the directories of the packages that
+// changed, which includes packages that were added or removed, along with their new states
+func waitForChanges(workspace string, states map[string]string, interval,
+	debounce time.Duration) (map[string]bool, map[string]string, error) {
+	changed := make(map[string]bool)
+	for {
+		time.Sleep(interval)
+		current, err := getSourceStates(workspace)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if len(diffSourceStates(states, current)) == 0 {
+			continue
+		}
+
+		// Keep waiting while files are still being written, e.g. by an editor saving several files
+		for {
+			for location := range diffSourceStates(states, current) {
+				changed[location] = true
+			}
+			states = current
+
+			time.Sleep(debounce)
+			if current, err = getSourceStates(workspace); err != nil {
+				return nil, nil, err
+			}
+
+			if len(diffSourceStates(states, current)) == 0 {
+				return changed, current, nil
+			}
+		}
+	}
+}
+
+// getSourceStates returns the source state of every workspace package, keyed by its directory
+func getSourceStates(workspace string) (map[string]string, error) {
+	workspacePackages, err := local.GetWorkspacePackages(workspace)
+	if err != nil {
+		return nil, fmt.Errorf("Error listing workspace packages: %+v", err)
+	}
+
+	states := make(map[string]string)
+	for _, parsedBuildfile := range workspacePackages {
+		state, err := local.GetSourceState(parsedBuildfile)
+		if err != nil {
+			// Files can disappear while they are listed. The next poll will see the result
+			buildlog.Debugf("%+v", err)
+		}
+		states[parsedBuildfile.AbsoluteWorkingDir] = state
+	}
+
+	return states, nil
+}
+
+func diffSourceStates(previous, current map[string]string) map[string]bool {
+	changed := make(map[string]bool)
+	for location, state := range current {
+		if previousState, ok := previous[location]; !ok || previousState != state {
+			changed[location] = true
+		}
+	}
+
+	for location := range previous {
+		if _, ok := current[location]; !ok {
+			changed[location] = true
+		}
+	}
+
+	return changed
+}
+
+// printWatchResults prints one line per build that was attempted
+func printWatchResults(results []*buildResult) {
+	for _, result := range results {
+		line := fmt.Sprintf("%-10s %s", result.status, result.planned.Buildfile.Package.String())
+		if result.status == buildStatusOK || result.status == buildStatusCached ||
+			result.status == buildStatusFailed {
+			line = fmt.Sprintf("%s in %s", line, result.duration.Round(time.Millisecond))
+		}
+
+		if result.status == buildStatusFailed || result.status == buildStatusSkipped {
+			buildlog.Errorf("%s", line)
+		} else {
+			buildlog.Infof("%s", line)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package commands
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/dimes/zbuild/buildlog"
+	"github.com/dimes/zbuild/cli/argv"
+	"github.com/dimes/zbuild/local"
+	"github.com/dimes/zbuild/model"
+)
+
+type why struct{}
+
+func (w *why) Describe() string {
+	return "Explains how namespace/name[/version] entered the dependency closure"
+}
+
+func (w *why) Exec(workingDir string, args ...string) error {
+	var resolver string
+	argSet := argv.NewArgSet()
+	argSet.ExpectString(&resolver, "resolver", local.TestResolverName, "the type of dependency resolver to use")
+	rest, err := argSet.Parse(args)
+	if err != nil {
+		return fmt.Errorf("Error parsing args: %+v", err)
+	}
+
+	if len(rest) != 1 {
+		return fmt.Errorf("Expected a single namespace/name[/version] argument")
+	}
+
+	parts := strings.Split(rest[0], "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return fmt.Errorf("Expected namespace/name[/version] but got %s", rest[0])
+	}
+	parts = append(parts, "")
+	namespace, name, version := parts[0], parts[1], parts[2]
+
+	dependencyResolver, err := local.GetDependencyResolver(resolver)
+	if err != nil {
+		return err
+	}
+
+	workspace, err := local.GetWorkspace(workingDir)
+	if err != nil {
+		return fmt.Errorf("Could not find workspace for %s: %+v", workingDir, err)
+	}
+
+	parsedBuildfile, err := model.ParseBuildfile(filepath.Join(workingDir, model.BuildfileName))
+	if err != nil {
+		return fmt.Errorf("Error parsing buildfile: %+v", err)
+	}
+
+	resolved, err := local.WalkDependencies(workspace, parsedBuildfile.Package, dependencyResolver)
+	if err != nil {
+		return fmt.Errorf("Error resolving dependencies of %s: %+v", parsedBuildfile.Package.String(), err)
+	}
+
+	printed := make(map[*local.ResolvedDependency]bool)
+	onPrintedPath := make(map[string]bool)
+	printChain := func(dependency *local.ResolvedDependency, suffix string) {
+		printed[dependency] = true
+		for _, hop := range dependency.Chain() {
+			onPrintedPath[hop.Artifact.String()] = true
+		}
+		buildlog.Outputf("%s\n%s\n", describeChain(dependency.Chain()), suffix)
+	}
+
+	for _, dependency := range resolved {
+		artifact := dependency.Artifact
+		if artifact.Namespace == namespace && artifact.Name == name &&
+			(version == "" || artifact.Version == version) {
+			printChain(dependency, "")
+		}
+
+		for _, excluded := range dependency.Excluded {
+			if excluded.Requested.Namespace == namespace && excluded.Requested.Name == name {
+				printChain(dependency, fmt.Sprintf("    excluded: %s\n", excluded.String()))
+			}
+		}
+	}
+	found := len(printed) > 0
+
+	// The dependencies of a package that is reached again are only walked the first time, so the
+	// other paths to it end there. They continue like a path that was already printed
+	for printedMore := found; printedMore; {
+		printedMore = false
+		for _, dependency := range resolved {
+			if dependency.Repeated && !printed[dependency] && onPrintedPath[dependency.Artifact.String()] {
+				printedMore = true
+				printChain(dependency, "  -> ... continues like the paths through it above\n")
+			}
+		}
+	}
+
+	if !found {
+		buildlog.Infof("%s is not in the %s dependency closure of %s", rest[0], resolver,
+			parsedBuildfile.Package.String())
+	}
+
+	return nil
+}
+
+// describeChain returns one line per hop from the root to the last dependency in the chain
+func describeChain(chain []*local.ResolvedDependency) string {
+	lines := make([]string, 0)
+	for i, hop := range chain {
+		origin := string(hop.Origin)
+		if hop.Artifact.BuildNumber != "" {
+			origin = fmt.Sprintf("%s build %s", origin, hop.Artifact.BuildNumber)
+		}
+
+		key := fmt.Sprintf("%s/%s/%s", hop.Artifact.Namespace, hop.Artifact.Name, hop.Artifact.Version)
+		if i == 0 {
+			lines = append(lines, fmt.Sprintf("%s (%s)", key, origin))
+			continue
+		}
+
+		requested := string(hop.Scope)
+		if hop.Requested.Version != hop.Artifact.Version {
+			requested = fmt.Sprintf("%s %s", requested, hop.Requested.Version)
+		}
+		lines = append(lines, fmt.Sprintf("  -> %s [%s] (%s)", key, requested, origin))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -6,29 +6,64 @@ import (
 	"github.com/dimes/zbuild/buildlog"
 	"github.com/dimes/zbuild/cli/argv"
 	"github.com/dimes/zbuild/cli/zbuild/commands"
+	"github.com/dimes/zbuild/local"
+	"github.com/dimes/zbuild/sandbox"
 )
 
 var (
 	knownCommands = map[string]commands.Command{
 		"build":          commands.Build,
+		"checkout":       commands.Checkout,
+		"clean":          commands.Clean,
+		"config":         commands.Config,
+		"deps":           commands.Deps,
+		"env":            commands.Env,
+		"graph":          commands.Graph,
 		"init-workspace": commands.InitWorkspace,
+		"local":          commands.LocalRepository,
+		"lock":           commands.Lock,
 		"publish":        commands.Publish,
 		"refresh":        commands.Refresh,
+		"status":         commands.Status,
+		"use-sourceset":  commands.UseSourceSet,
+		"watch":          commands.Watch,
+		"why":            commands.Why,
 	}
 )
 
 func main() {
 	var verbose bool
+	var offline bool
 	argSet := argv.NewArgSet()
 	argSet.ExpectBool(&verbose, "v", false, "enable verbose logging")
+	argSet.ExpectBool(&offline, "offline", false, "only use artifacts that are already downloaded")
 	rest, err := argSet.Parse(os.Args[1:])
 	if err != nil {
 		buildlog.Fatalf("Error parsing args: %+v", err)
 	}
 
+	workingDir, err := os.Getwd()
+	if err != nil {
+		buildlog.Fatalf("Error getting working directory: %+v", err)
+	}
+
 	buildlog.SetLogLevel(buildlog.Info)
 	if verbose {
 		buildlog.SetLogLevel(buildlog.Debug)
+	} else if level, err := local.GetConfiguredLogLevel(workingDir); err != nil {
+		buildlog.Warningf("Error reading log level from workspace config: %+v", err)
+	} else {
+		buildlog.SetLogLevel(level)
+	}
+
+	local.SetOffline(offline)
+
+	// Hermetic builds re-execute zbuild in a sandbox
+	if sandbox.IsSandboxed() {
+		if err := commands.BuildInSandbox(); err != nil {
+			buildlog.Fatalf("Error building in sandbox: %+v", err)
+		}
+		return
 	}
 
 	if len(rest) == 0 {
@@ -45,11 +80,6 @@ func main() {
 		os.Exit(1)
 	}
 
-	workingDir, err := os.Getwd()
-	if err != nil {
-		buildlog.Fatalf("Error getting working directory: %+v", err)
-	}
-
 	if err := command.Exec(workingDir, rest[1:]...); err != nil {
 		buildlog.Fatalf("Error executing command %s: %+v", commandName, err)
 	}
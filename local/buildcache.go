@@ -0,0 +1,236 @@
+package local
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+
+	"github.com/dimes/zbuild/artifacts"
+	"github.com/dimes/zbuild/buildlog"
+	"github.com/dimes/zbuild/model"
+)
+
+const (
+	// BuildCacheLocal stores build outputs in the user-level zbuild directory, shared by all of the
+	// user's workspaces
+	BuildCacheLocal = "local"
+
+	// BuildCacheRemote stores build outputs with the workspace's remote manager, shared by everyone
+	// using it
+	BuildCacheRemote = "remote"
+
+	buildCacheDirName     = "build-cache"
+	buildCacheBuildPrefix = "cache-"
+)
+
+var (
+	// machineEnv are inherited host variables that differ between machines without changing build
+	// outputs, so they are left out of build cache keys. PATH and GOROOT select the toolchain, whose
+	// version is part of the key instead
+	machineEnv = map[string]bool{
+		"HOME": true, "USER": true, "LOGNAME": true, "PATH": true, "SHELL": true, "TMPDIR": true,
+		"GOCACHE": true, "GOROOT": true, "GOTMPDIR": true,
+	}
+)
+
+// boundedBuildCache is a build cache on disk. Restoring an output marks it as used, and the least
+// recently used outputs are removed when the cache exceeds its size
+type boundedBuildCache struct {
+	*repositoryManager
+	size int64
+}
+
+// GetBuildCache returns the manager that build outputs are cached with, or nil if the workspace
+// doesn't use a build cache
+func GetBuildCache(workspace string) (artifacts.Manager, error) {
+	workspaceConfig, err := GetWorkspaceConfig(workspace)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading workspace config: %+v", err)
+	}
+
+	size, err := workspaceConfig.GetBuildCacheSizeBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	switch workspaceConfig.BuildCache {
+	case "":
+		return nil, nil
+	case BuildCacheLocal:
+		userDir, err := GetUserDir()
+		if err != nil {
+			return nil, err
+		}
+		return &boundedBuildCache{
+			repositoryManager: &repositoryManager{root: filepath.Join(userDir, buildCacheDirName)},
+			size:              size,
+		}, nil
+	case BuildCacheRemote:
+		manager, err := GetRemoteManager(workspace)
+		if err == ErrOffline {
+			buildlog.Debugf("Not using the remote build cache because zbuild is offline")
+			return nil, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("Error getting remote manager for the build cache: %+v", err)
+		}
+		return manager, nil
+	default:
+		return &boundedBuildCache{
+			repositoryManager: &repositoryManager{root: workspaceConfig.BuildCache},
+			size:              size,
+		}, nil
+	}
+}
+
+// GetBuildCacheKey returns the key that the package's build output is cached under. The fingerprint
+// digest only covers the package's inputs, so the key adds what makes the same inputs build
+// differently elsewhere: the host platform, the toolchain reported by the builder, whether the build
+// is hermetic and the variables inherited from the host, except for machineEnv
+func GetBuildCacheKey(digest string, env *BuildEnv, toolchain string, hermetic bool) string {
+	key := &bytes.Buffer{}
+	fmt.Fprintf(key, "fingerprint %s\n", digest)
+	fmt.Fprintf(key, "platform %s/%s\n", runtime.GOOS, runtime.GOARCH)
+	fmt.Fprintf(key, "toolchain %s\n", toolchain)
+	fmt.Fprintf(key, "hermetic %t\n", hermetic)
+	for _, envVar := range env.Vars() {
+		if envVar.Origin == EnvOriginHost && !machineEnv[envVar.Name] {
+			fmt.Fprintf(key, "env %s=%s\n", envVar.Name, envVar.Value)
+		}
+	}
+	return digestBytes(key.Bytes())
+}
+
+// RestoreBuild restores the package's build directory from the output of a previous build with the
+// same build cache key. Returns false if the cache has no such output
+func RestoreBuild(cache artifacts.Manager, parsedBuildfile *model.ParsedBuildfile, key string) (bool, error) {
+	reader, err := cache.OpenReader(buildCacheArtifact(parsedBuildfile, key))
+	if err != nil {
+		buildlog.Debugf("Build cache miss for %s: %+v", parsedBuildfile.Package.String(), err)
+		return false, nil
+	}
+	defer reader.Close()
+
+	buildDir := parsedBuildfile.AbsoluteBuildDir
+	if err := os.MkdirAll(buildDir, 0755); err != nil {
+		return false, fmt.Errorf("Error creating build directory %s: %+v", buildDir, err)
+	}
+
+	if err := extractTarball(reader, buildDir); err != nil {
+		// A broken cache entry is treated as a miss, so the package is built instead
+		buildlog.Warningf("Error restoring %s from the build cache: %+v", parsedBuildfile.Package.String(), err)
+		if err := os.RemoveAll(buildDir); err != nil {
+			return false, fmt.Errorf("Error removing build directory %s: %+v", buildDir, err)
+		}
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// StoreBuild stores the package's build directory in the cache under its build cache key
+func StoreBuild(cache artifacts.Manager, parsedBuildfile *model.ParsedBuildfile, key string) error {
+	if err := cache.Setup(); err != nil {
+		return fmt.Errorf("Error setting up build cache: %+v", err)
+	}
+
+	artifact := buildCacheArtifact(parsedBuildfile, key)
+	writer, err := cache.OpenWriter(artifact)
+	if err != nil {
+		return fmt.Errorf("Error opening build cache writer: %+v", err)
+	}
+
+	if err := writeTarball(parsedBuildfile.AbsoluteBuildDir, writer); err != nil {
+		writer.Close()
+		return fmt.Errorf("Error writing %s to the build cache: %+v", parsedBuildfile.AbsoluteBuildDir, err)
+	}
+
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	if bounded, ok := cache.(*boundedBuildCache); ok {
+		bounded.prune(repositoryArtifactLocation(bounded.root, artifact))
+	}
+	return nil
+}
+
+// buildCacheArtifact returns the artifact that a build output is cached as. Cached builds use their
+// build cache key as the build number, so they never collide with published builds
+func buildCacheArtifact(parsedBuildfile *model.ParsedBuildfile, key string) *model.Artifact {
+	return &model.Artifact{
+		Package:     parsedBuildfile.Package,
+		BuildNumber: buildCacheBuildPrefix + key,
+	}
+}
+
+// OpenReader opens the cached output and marks it as used, so it is pruned last
+func (c *boundedBuildCache) OpenReader(artifact *model.Artifact) (io.ReadCloser, error) {
+	reader, err := c.repositoryManager.OpenReader(artifact)
+	if err != nil {
+		return nil, err
+	}
+
+	markCacheUse(repositoryArtifactLocation(c.root, artifact))
+	return reader, nil
+}
+
+// prune removes the least recently used outputs until the cache fits in its size. The output that was
+// just stored is kept, even if it alone exceeds the size. A size of 0 disables pruning
+func (c *boundedBuildCache) prune(stored string) {
+	if c.size <= 0 {
+		return
+	}
+
+	locations, err := filepath.Glob(filepath.Join(c.root, "*", "*", "*",
+		buildCacheBuildPrefix+"*"+repositoryArtifactExt))
+	if err != nil {
+		buildlog.Warningf("Error listing the build cache %s: %+v", c.root, err)
+		return
+	}
+
+	outputs := make([]*cachedArtifact, 0, len(locations))
+	total := int64(0)
+	for _, location := range locations {
+		info, err := os.Stat(location)
+		if err != nil {
+			// Another process may have pruned it already
+			buildlog.Debugf("Error reading %s in the build cache: %+v", location, err)
+			continue
+		}
+
+		outputs = append(outputs, &cachedArtifact{
+			location: location,
+			size:     info.Size(),
+			lastUsed: info.ModTime(),
+		})
+		total += info.Size()
+	}
+
+	sort.Slice(outputs, func(i, j int) bool {
+		return outputs[i].lastUsed.Before(outputs[j].lastUsed)
+	})
+
+	for _, output := range outputs {
+		if total <= c.size {
+			return
+		}
+
+		if output.location == stored {
+			continue
+		}
+
+		buildlog.Debugf("Removing %s from the build cache", output.location)
+		if err := os.Remove(output.location); err != nil && !os.IsNotExist(err) {
+			buildlog.Warningf("Error removing %s from the build cache: %+v", output.location, err)
+			continue
+		}
+		total -= output.size
+
+		// Remove the version directory once its last output is gone. This fails if it isn't empty
+		os.Remove(filepath.Dir(output.location))
+	}
+}
@@ -3,56 +3,74 @@ package local
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/dimes/zbuild/artifacts"
 	"github.com/dimes/zbuild/buildlog"
 	"github.com/dimes/zbuild/model"
+	"github.com/dimes/zbuild/versions"
 )
 
-var (
-	// CompileDependencyResolver resolves compile-time dependencies
-	CompileDependencyResolver DependencyResolver = &compileResolver{}
-
-	// TestDependencyResolver resolves test-dependencies
-	TestDependencyResolver DependencyResolver = &testResolver{compileResolver: &compileResolver{}}
-)
-
-// DependencyResolver resolves different types of dependencies, e.g. test, compile, runtime, etc.
-type DependencyResolver interface {
-	GetDependencies(target model.Package) []model.Package
-}
-
-type compileResolver struct {
+type buildpathGenerator struct {
+	workspace           string
+	localSourceSet      *localSourceSet
+	overrideSourceSet   *overrideSourceSet
+	repositorySourceSet *repositorySourceSet
+	localManager        artifacts.Manager
+	repositoryManager   artifacts.Manager
+	upstreamManager     artifacts.Manager
+
+	// lock contains the builds recorded in the lock file of the package being resolved. It is nil
+	// when the lock file should not be honored
+	lock *Lockfile
+
+	// missing records the artifacts that would have been downloaded while offline, i.e. when there
+	// is no upstream manager. Unless tolerateMissing is set, resolving fails if there are any
+	missing         map[string]*model.Artifact
+	tolerateMissing bool
+
+	// cacheSize is the maximum size of the package cache in bytes, or 0 if it is unlimited. The cache
+	// is only pruned after downloading something
+	cacheSize  int64
+	downloaded bool
+
+	// sourceSetOnly resolves every package except the root against the workspace's source set,
+	// ignoring packages checked out in the workspace and published to the local repository
+	sourceSetOnly bool
 }
 
-func (c *compileResolver) GetDependencies(target model.Package) []model.Package {
-	return target.Dependencies.Compile
-}
+func newBuildpathGenerator(path string) (*buildpathGenerator, error) {
+	buildpathGenerator, err := newLocalBuildpathGenerator(path)
+	if err != nil {
+		return nil, err
+	}
 
-type testResolver struct {
-	compileResolver *compileResolver
-}
+	workspaceConfig, err := GetWorkspaceConfig(buildpathGenerator.workspace)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading workspace config: %+v", err)
+	}
 
-func (t *testResolver) GetDependencies(target model.Package) []model.Package {
-	return append(t.compileResolver.GetDependencies(target), target.Dependencies.Test...)
-}
+	// Remote clients are never constructed while offline
+	if offline || workspaceConfig.Offline {
+		buildlog.Debugf("Offline, only using artifacts in the package cache")
+	} else if buildpathGenerator.upstreamManager, err = GetRemoteManager(buildpathGenerator.workspace); err != nil {
+		return nil, fmt.Errorf("Error getting remote manager: %+v", err)
+	}
 
-type buildpathGenerator struct {
-	workspace         string
-	localSourceSet    artifacts.SourceSet
-	overrideSourceSet *overrideSourceSet
-	localManager      artifacts.Manager
-	upstreamManager   artifacts.Manager
+	return buildpathGenerator, nil
 }
 
-func newBuildpathGenerator(path string) (*buildpathGenerator, error) {
+// newLocalBuildpathGenerator returns a generator without an upstream manager, which only resolves
+// artifacts that are in the workspace, the local repository or the package cache
+func newLocalBuildpathGenerator(path string) (*buildpathGenerator, error) {
 	workspace, err := GetWorkspace(path)
 	if err != nil {
 		return nil, fmt.Errorf("Error getting workspace for %s: %+v", path, err)
 	}
 
-	localSourceSet, err := NewLocalSourceSet(workspace)
+	localSourceSet, err := newWorkspaceSourceSet(workspace)
 	if err != nil {
 		return nil, fmt.Errorf("Error creating local source set: %+v", err)
 	}
@@ -62,116 +80,756 @@ func newBuildpathGenerator(path string) (*buildpathGenerator, error) {
 		return nil, fmt.Errorf("Error creating override source set: %+v", err)
 	}
 
+	repositorySourceSet, err := getRepositorySourceSet()
+	if err != nil {
+		return nil, fmt.Errorf("Error creating local repository source set: %+v", err)
+	}
+
 	localManager, err := NewLocalManager(workspace)
 	if err != nil {
 		return nil, fmt.Errorf("Error creating local manager: %+v", err)
 	}
 
-	upstreamManager, err := GetRemoteManager(workspace)
+	repositoryManager, err := GetRepositoryManager()
 	if err != nil {
-		return nil, fmt.Errorf("Error getting remote manager: %+v", err)
+		return nil, fmt.Errorf("Error creating local repository manager: %+v", err)
+	}
+
+	workspaceConfig, err := GetWorkspaceConfig(workspace)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading workspace config: %+v", err)
+	}
+
+	cacheSize, err := workspaceConfig.GetCacheSizeBytes()
+	if err != nil {
+		return nil, err
 	}
 
 	return &buildpathGenerator{
-		workspace:         workspace,
-		localSourceSet:    localSourceSet,
-		overrideSourceSet: overrideSourceSet,
-		localManager:      localManager,
-		upstreamManager:   upstreamManager,
+		workspace:           workspace,
+		localSourceSet:      localSourceSet,
+		overrideSourceSet:   overrideSourceSet,
+		repositorySourceSet: repositorySourceSet,
+		localManager:        localManager,
+		repositoryManager:   repositoryManager,
+		missing:             make(map[string]*model.Artifact),
+		cacheSize:           cacheSize,
 	}, nil
 }
 
-// getArtifact returns the artifact for the given package, as well as its location in the local FS
-func (b *buildpathGenerator) getArtifact(target model.Package) (*model.Artifact, string, error) {
-	artifactLocation := ""
-	artifact, err := b.overrideSourceSet.GetArtifact(target.Namespace, target.Name, target.Version)
-	if err == artifacts.ErrArtifactNotFound {
-		artifact, err = b.localSourceSet.GetArtifact(target.Namespace, target.Name, target.Version)
-		if err != nil {
-			return nil, "", fmt.Errorf("Error getting artifact for %s: %+v", target.String(), err)
+// resolveVersion returns the target with its version constraint, if any, replaced by the highest
+// matching version known to the workspace, the local repository or the source set. A matching
+// version recorded in the lock file is used instead when there is one. The workspace and local
+// repository are only considered if allowLocal is set
+func (b *buildpathGenerator) resolveVersion(target model.Package, allowLocal bool) (model.Package, error) {
+	if target.Version == model.InUseVersion {
+		return b.resolveInUseVersion(target, allowLocal)
+	}
+
+	if !versions.IsConstraint(target.Version) {
+		return target, nil
+	}
+
+	constraint, err := versions.ParseConstraint(target.Version)
+	if err != nil {
+		return target, fmt.Errorf("Invalid version for %s/%s: %+v", target.Namespace, target.Name, err)
+	}
+
+	if locked := b.lock.getArtifact(target.Namespace, target.Name); locked != nil &&
+		constraint.Matches(locked.Version) {
+		buildlog.Debugf("Using locked version %s of %s/%s", locked.Version, target.Namespace, target.Name)
+		target.Version = locked.Version
+		return target, nil
+	}
+
+	sourceSets := []*localSourceSet{b.localSourceSet}
+	if allowLocal {
+		sourceSets = []*localSourceSet{
+			&b.overrideSourceSet.localSourceSet,
+			&b.repositorySourceSet.localSourceSet,
+			b.localSourceSet,
 		}
+	}
 
-		artifactLocation = localArtifactCacheDir(b.workspace, artifact)
-		if _, err = os.Stat(artifactLocation); err != nil {
-			buildlog.Debugf("Downloading %s", artifact.String())
-			if err = artifacts.Transfer(b.upstreamManager, b.localManager, artifact); err != nil {
-				return nil, "", fmt.Errorf("Error downloading artifact %s: %+v", artifact.String(), err)
+	seenVersions := make(map[string]bool)
+	candidates := make([]string, 0)
+	for _, sourceSet := range sourceSets {
+		for _, version := range sourceSet.getVersions(target.Namespace, target.Name) {
+			if !seenVersions[version] {
+				seenVersions[version] = true
+				candidates = append(candidates, version)
 			}
 		}
-	} else if err != nil {
-		return nil, "", fmt.Errorf("Error getting artifact from overide source set: %+v", err)
-	} else {
-		artifactLocation, err = b.overrideSourceSet.getLocationForArtifact(
-			target.Namespace,
-			target.Name,
-			target.Version)
-		if err != nil {
-			return nil, "",
-				fmt.Errorf("Error getting artifact location for %s: %+v", artifact.String(), err)
+	}
+
+	version, ok := constraint.Highest(candidates)
+	if !ok {
+		if len(candidates) == 0 {
+			return target, fmt.Errorf("No versions of %s/%s are available to satisfy %s",
+				target.Namespace, target.Name, constraint)
 		}
+
+		versions.Sort(candidates)
+		return target, fmt.Errorf("No version of %s/%s matches %s. Available versions are: %s",
+			target.Namespace, target.Name, constraint, strings.Join(candidates, ", "))
+	}
+
+	buildlog.Debugf("Resolved %s/%s %s to version %s", target.Namespace, target.Name, constraint, version)
+	target.Version = version
+	return target, nil
+}
+
+// resolveInUseVersion returns the target with the version the source set has in use, or the locked
+// version if there is one. If the source set uses several versions, the highest is used. Packages
+// checked out in the workspace and in the local repository don't change the version, but still
+// override the source set's build of it. Only packages the source set doesn't use at all resolve to
+// the highest version in the workspace or local repository, if allowLocal is set
+func (b *buildpathGenerator) resolveInUseVersion(target model.Package, allowLocal bool) (model.Package, error) {
+	if locked := b.lock.getArtifact(target.Namespace, target.Name); locked != nil {
+		buildlog.Debugf("Using locked version %s of %s/%s", locked.Version, target.Namespace, target.Name)
+		target.Version = locked.Version
+		return target, nil
+	}
+
+	inUse := b.localSourceSet.getVersions(target.Namespace, target.Name)
+	usedBy := "the source set"
+	if len(inUse) == 0 && allowLocal {
+		inUse = append(b.overrideSourceSet.getVersions(target.Namespace, target.Name),
+			b.repositorySourceSet.getVersions(target.Namespace, target.Name)...)
+		usedBy = "the workspace or local repository"
 	}
 
-	return artifact, artifactLocation, nil
+	if len(inUse) == 0 {
+		return target, fmt.Errorf("%s/%s doesn't declare a version and the source set %s doesn't use any "+
+			"version of it", target.Namespace, target.Name, b.localSourceSet.name)
+	}
+
+	versions.Sort(inUse)
+	target.Version = inUse[len(inUse)-1]
+	buildlog.Debugf("Resolved %s/%s to version %s in %s", target.Namespace, target.Name, target.Version, usedBy)
+	return target, nil
+}
+
+// getArtifact resolves the given package to an artifact and its location in the local FS. Packages
+// checked out in the workspace take precedence, followed by packages published to the user's local
+// repository, the builds recorded in the lock file and finally the workspace's source set. The
+// workspace and local repository are skipped unless allowLocal is set. Version constraints are
+// resolved before looking up the artifact
+func (b *buildpathGenerator) getArtifact(target model.Package, allowLocal bool) (*ResolvedDependency, error) {
+	requested := target
+	target, err := b.resolveVersion(target, allowLocal)
+	if err != nil {
+		return nil, err
+	}
+
+	if allowLocal {
+		artifact, err := b.overrideSourceSet.GetArtifact(target.Namespace, target.Name, target.Version)
+		if err == nil {
+			artifactLocation, err := b.overrideSourceSet.getLocationForArtifact(
+				target.Namespace,
+				target.Name,
+				target.Version)
+			if err != nil {
+				return nil, fmt.Errorf("Error getting artifact location for %s: %+v", artifact.String(), err)
+			}
+
+			return &ResolvedDependency{
+				Requested: requested,
+				Artifact:  artifact,
+				Location:  artifactLocation,
+				Origin:    OriginWorkspace,
+			}, nil
+		} else if err != artifacts.ErrArtifactNotFound {
+			return nil, fmt.Errorf("Error getting artifact from overide source set: %+v", err)
+		}
+	}
+
+	var artifact *model.Artifact
+	manager := b.upstreamManager
+	origin := OriginSourceSet
+	if allowLocal {
+		artifact, err = b.repositorySourceSet.GetArtifact(target.Namespace, target.Name, target.Version)
+		if err == nil {
+			buildlog.Debugf("Using locally published %s build %s", artifact.String(), artifact.BuildNumber)
+			manager = b.repositoryManager
+			origin = OriginLocalRepository
+		} else if err != artifacts.ErrArtifactNotFound {
+			return nil, fmt.Errorf("Error getting artifact from local repository: %+v", err)
+		}
+	}
+
+	if artifact == nil {
+		if locked := b.lock.getArtifact(target.Namespace, target.Name); locked != nil &&
+			locked.Version == target.Version {
+			buildlog.Debugf("Using locked %s build %s", locked.String(), locked.BuildNumber)
+			artifact = locked
+		} else if artifact, err = b.localSourceSet.GetArtifact(target.Namespace, target.Name,
+			target.Version); err != nil {
+			return nil, fmt.Errorf("Error getting artifact for %s: %+v", target.String(), err)
+		}
+	}
+
+	artifactLocation := localArtifactCacheDir(b.workspace, artifact)
+	if err := b.ensureCached(manager, artifact, artifactLocation); err != nil {
+		return nil, err
+	}
+
+	return &ResolvedDependency{
+		Requested: requested,
+		Artifact:  artifact,
+		Location:  artifactLocation,
+		Origin:    origin,
+	}, nil
+}
+
+// ensureCached downloads the artifact to the location in the package cache, unless it is already
+// there. Without a manager, the artifact is only recorded as missing. Only one download of each
+// artifact happens at a time, and the artifact isn't pruned during the rest of the build run
+func (b *buildpathGenerator) ensureCached(manager artifacts.Manager, artifact *model.Artifact,
+	artifactLocation string) error {
+	pinCachedArtifact(artifactLocation)
+	unlock := lockDownload(artifactLocation)
+	defer unlock()
+
+	if _, err := os.Stat(artifactLocation); err != nil {
+		if manager == nil {
+			buildlog.Debugf("%s build %s is not in the package cache", artifact.String(), artifact.BuildNumber)
+			b.missing[artifactLocation] = artifact
+			return nil
+		}
+
+		return b.download(manager, artifact)
+	}
+
+	if b.cacheSize > 0 {
+		markCacheUse(artifactLocation)
+	}
+	return nil
+}
+
+// download transfers the artifact into the workspace package cache. If the artifact has a digest,
+// the downloaded bytes must match it
+func (b *buildpathGenerator) download(manager artifacts.Manager, artifact *model.Artifact) error {
+	buildlog.Debugf("Downloading %s", artifact.String())
+	digest, err := artifacts.TransferWithDigest(manager, b.localManager, artifact)
+	if err != nil {
+		return fmt.Errorf("Error downloading artifact %s: %+v", artifact.String(), err)
+	}
+	b.downloaded = true
+
+	if artifact.Digest != "" && artifact.Digest != digest {
+		artifactLocation := localArtifactCacheDir(b.workspace, artifact)
+		if err := os.RemoveAll(artifactLocation); err != nil {
+			buildlog.Warningf("Error removing %s: %+v", artifactLocation, err)
+		}
+		return fmt.Errorf("Digest mismatch for %s build %s. Expected %s but downloaded %s",
+			artifact.String(), artifact.BuildNumber, artifact.Digest, digest)
+	}
+
+	return nil
+}
+
+// checkMissing returns an error listing every artifact that could not be downloaded while offline
+func (b *buildpathGenerator) checkMissing() error {
+	if len(b.missing) == 0 {
+		return nil
+	}
+
+	missing := make([]string, 0, len(b.missing))
+	for _, artifact := range b.missing {
+		missing = append(missing, fmt.Sprintf("%s build %s", artifact.String(), artifact.BuildNumber))
+	}
+	sort.Strings(missing)
+
+	return fmt.Errorf("Offline, but these artifacts are not in the package cache:\n  %s",
+		strings.Join(missing, "\n  "))
 }
 
 // GetArtifactLocation gets the artifact for the given package. It uses the path to determine the
 // workspace
 func GetArtifactLocation(path string, target model.Package) (string, error) {
-	buildpathGenerator, err := newBuildpathGenerator(path)
+	buildpathGenerator, err := getBuildpathGenerator(path)
 	if err != nil {
 		return "", fmt.Errorf("Error getting buildpath generator for %s: %+v", path, err)
 	}
 
-	_, artifactLocation, err := buildpathGenerator.getArtifact(target)
+	resolved, err := buildpathGenerator.getArtifact(target, true)
 	if err != nil {
 		return "", fmt.Errorf("Error getting artifact for %+v: %+v", target, err)
 	}
 
-	return artifactLocation, nil
+	if err := buildpathGenerator.checkMissing(); err != nil {
+		return "", err
+	}
+
+	return resolved.Location, nil
 }
 
-// GetBuildpath returns a path to all packages required for the build
-func GetBuildpath(workspace string, target model.Package, resolver DependencyResolver) ([]string, error) {
-	buildpathGenerator, err := newBuildpathGenerator(workspace)
-	if err != nil {
-		return nil, fmt.Errorf("Error getting buildpath generator for %s: %+v", workspace, err)
+// Origin identifies where a resolved artifact came from
+type Origin string
+
+const (
+	// OriginWorkspace artifacts are packages checked out in the workspace
+	OriginWorkspace Origin = "workspace"
+
+	// OriginLocalRepository artifacts were published to the user's local repository
+	OriginLocalRepository Origin = "local"
+
+	// OriginSourceSet artifacts come from the workspace's source set
+	OriginSourceSet Origin = "sourceset"
+)
+
+// ResolvedDependency is a package reached while walking the dependency graph, along with the
+// artifact it resolved to
+type ResolvedDependency struct {
+	Requested model.Package       // The package as declared by its dependent. The version may be a constraint
+	Scope     model.Scope         // The scope the package was declared in. Empty for the root package
+	Artifact  *model.Artifact     // The artifact the package resolved to
+	Location  string              // The location of the artifact in the local FS
+	Origin    Origin              // Where the artifact came from
+	Depth     int                 // The number of dependency hops from the root package
+	Parent    *ResolvedDependency // The dependent that declared this dependency. Nil for the root package
+	Excluded  []*ExcludedDependency
+
+	// Repeated is set if the package has dependencies, but they are only listed where the package was
+	// first reached with the same exclusions
+	Repeated bool
+}
+
+// ExcludedDependency is a dependency that was left out of the closure because of an exclusion
+type ExcludedDependency struct {
+	Requested  model.Package
+	Scope      model.Scope
+	Exclusion  model.Exclusion
+	ExcludedBy *ResolvedDependency // The dependency whose build file entry declared the exclusion
+}
+
+// String returns a human readable description of the exclusion
+func (e *ExcludedDependency) String() string {
+	return fmt.Sprintf("%s/%s %s [%s] excluded by %s", e.Requested.Namespace, e.Requested.Name,
+		e.Requested.Version, e.Scope, e.ExcludedBy.Path())
+}
+
+// activeExclusion is an exclusion that applies to a subtree of the dependency graph
+type activeExclusion struct {
+	exclusion  model.Exclusion
+	excludedBy *ResolvedDependency
+}
+
+// String returns a human readable description of the resolution, e.g.
+// "ns/lib ^1.2 => 1.3 (build 12) [compile]"
+func (r *ResolvedDependency) String() string {
+	description := fmt.Sprintf("%s/%s %s", r.Requested.Namespace, r.Requested.Name, r.Requested.Version)
+	if r.Requested.Version != r.Artifact.Version {
+		description = fmt.Sprintf("%s => %s", description, r.Artifact.Version)
 	}
 
-	paths := make([]string, 0)
+	if r.Artifact.BuildNumber != "" {
+		description = fmt.Sprintf("%s (build %s)", description, r.Artifact.BuildNumber)
+	}
+
+	if r.Scope != "" {
+		description = fmt.Sprintf("%s [%s]", description, r.Scope)
+	}
+
+	return description
+}
+
+// OutputDir returns the directory containing the artifact's build output. For packages checked out
+// in the workspace this is the package's build directory
+func (r *ResolvedDependency) OutputDir() string {
+	if r.Origin == OriginWorkspace {
+		return filepath.Join(r.Location, model.BuildDir)
+	}
+	return r.Location
+}
+
+// Chain returns the dependencies, starting at the root and ending with this one, through which this
+// dependency was reached
+func (r *ResolvedDependency) Chain() []*ResolvedDependency {
+	chain := make([]*ResolvedDependency, 0)
+	for dependency := r; dependency != nil; dependency = dependency.Parent {
+		chain = append([]*ResolvedDependency{dependency}, chain...)
+	}
+	return chain
+}
+
+// Path returns the chain of packages, starting at the root, through which this dependency was reached
+func (r *ResolvedDependency) Path() string {
+	path := make([]string, 0)
+	for _, dependency := range r.Chain() {
+		path = append(path, packageToMapKey(dependency.Artifact.Package))
+	}
+	return strings.Join(path, " -> ")
+}
+
+// GetResolvedDependencies walks the dependency graph of the target and returns every package reached,
+// in the order they were visited. The first entry is always the target itself.
+//
+// Version conflicts, i.e. multiple versions of the same namespace/name in the closure, are handled
+// according to the resolution options in the target's build file.
+func GetResolvedDependencies(workspace string, target model.Package,
+	resolver DependencyResolver) ([]*ResolvedDependency, error) {
+	return resolveClosure(workspace, target, resolver, false)
+}
+
+// WalkDependencies is like GetResolvedDependencies, but conflicts are only reported as warnings when
+// the conflict policy is to fail. This allows inspecting every version of a package that the
+// dependency graph reaches
+func WalkDependencies(workspace string, target model.Package,
+	resolver DependencyResolver) ([]*ResolvedDependency, error) {
+	return resolveClosure(workspace, target, resolver, true)
+}
+
+// resolve walks the dependency graph until it contains no version conflicts. Each iteration of the
+// newest policy selects a version for the conflicting packages, which can change the rest of the graph
+func (b *buildpathGenerator) resolve(target model.Package, resolver DependencyResolver,
+	tolerateConflicts bool) ([]*ResolvedDependency, error) {
+	policy := target.Resolution.Conflicts
+	if policy == "" {
+		policy = model.ConflictPolicyFail
+	}
+
+	if policy != model.ConflictPolicyFail && policy != model.ConflictPolicyNewest {
+		return nil, fmt.Errorf("Unknown conflict policy %s. Expected %s or %s", policy,
+			model.ConflictPolicyFail, model.ConflictPolicyNewest)
+	}
+
+	selections := make(map[string]string)
+	for _, pin := range target.Resolution.Pins {
+		buildlog.Debugf("Pinning %s/%s to %s", pin.Namespace, pin.Name, pin.Version)
+		selections[packageNameKey(pin.Namespace, pin.Name)] = pin.Version
+	}
+
+	for {
+		b.missing = make(map[string]*model.Artifact)
+		resolved, err := b.walk(target, resolver, selections)
+		if err != nil {
+			return nil, err
+		}
+
+		conflicts := findConflicts(resolved)
+		if len(conflicts) > 0 && policy == model.ConflictPolicyFail && tolerateConflicts {
+			for _, conflict := range conflicts {
+				buildlog.Warningf("%s", conflict.String())
+			}
+			conflicts = nil
+		}
+
+		if len(conflicts) == 0 {
+			if !b.tolerateMissing {
+				if err := b.checkMissing(); err != nil {
+					return nil, err
+				}
+			}
+
+			if b.downloaded && b.cacheSize > 0 {
+				b.pruneCache()
+			}
+			return resolved, nil
+		}
+
+		if policy == model.ConflictPolicyFail {
+			for _, conflict := range conflicts {
+				buildlog.Errorf("%s", conflict.String())
+			}
+			return nil, fmt.Errorf("Found conflicting versions of %s. Add pins or set the conflict "+
+				"policy to %s in the resolution section of %s", conflictNames(conflicts),
+				model.ConflictPolicyNewest, model.BuildfileName)
+		}
+
+		// Selections are never applied to the root, and a selection that is already in place can't
+		// remove the conflict, so walking again would find the same conflicts forever
+		for _, conflict := range conflicts {
+			key := packageNameKey(conflict.namespace, conflict.name)
+			if conflict.namespace == target.Namespace && conflict.name == target.Name {
+				buildlog.Errorf("%s", conflict.String())
+				return nil, fmt.Errorf("%s depends on another version of itself through its dependencies. "+
+					"Exclude it from the dependencies that declare it", key)
+			}
+
+			newest := conflict.newest()
+			if selections[key] == newest {
+				buildlog.Errorf("%s", conflict.String())
+				return nil, fmt.Errorf("Selecting version %s of %s doesn't resolve the conflict", newest, key)
+			}
+
+			buildlog.Warningf("%s\nUsing the newest version %s", conflict.String(), newest)
+			selections[key] = newest
+		}
+	}
+}
+
+// walk visits every path through the dependency graph. A package that is reached again with the same
+// exclusions is listed for each path, but its dependencies are only walked the first time, since they
+// would be the same. Packages with a selected version, i.e. a pin or the winner of a conflict, use the
+// selected version instead of the declared one
+func (b *buildpathGenerator) walk(target model.Package, resolver DependencyResolver,
+	selections map[string]string) ([]*ResolvedDependency, error) {
+	resolved := make([]*ResolvedDependency, 0)
 	seenPackages := make(map[string]bool)
+	expanded := make(map[string]bool) // Whether the walked packages have any dependencies
 	stack := []*stackEntry{{target: target}}
 	for len(stack) > 0 {
 		entry := stack[len(stack)-1]
-		target := entry.target
-		targetKey := packageToMapKey(target)
 		if entry.visited {
-			delete(seenPackages, targetKey)
+			delete(seenPackages, entry.key)
 			stack = stack[:len(stack)-1]
 			continue
 		}
 
-		if seenPackages[targetKey] {
+		selected := entry.target
+		if entry.parent != nil {
+			if version, ok := selections[packageNameKey(selected.Namespace, selected.Name)]; ok {
+				selected.Version = version
+			}
+		}
+
+		dependency, err := b.getArtifact(selected, entry.parent == nil || !b.sourceSetOnly)
+		if err != nil {
+			return nil, fmt.Errorf("Error getting artifact for %+v: %+v", entry.target, err)
+		}
+
+		artifact := dependency.Artifact
+		entry.key = packageToMapKey(artifact.Package)
+		if seenPackages[entry.key] {
 			cycle := make([]string, 0)
-			for _, entry := range stack {
-				cycle = append(cycle, packageToMapKey(entry.target))
+			for _, pathEntry := range stack {
+				if pathEntry.visited {
+					cycle = append(cycle, pathEntry.key)
+				}
 			}
+			cycle = append(cycle, entry.key)
 			return nil, fmt.Errorf("Dependency cycle detected: %s", strings.Join(cycle, " -> "))
 		}
 
-		seenPackages[targetKey] = true
+		seenPackages[entry.key] = true
 		entry.visited = true
 
-		artifact, artifactLocation, err := buildpathGenerator.getArtifact(target)
-		if err != nil {
-			return nil, fmt.Errorf("Error getting artifact for %+v: %+v", target, err)
+		dependency.Requested = entry.target
+		dependency.Scope = entry.scope
+		dependency.Parent = entry.parent
+		if entry.parent != nil {
+			dependency.Depth = entry.parent.Depth + 1
+		}
+		resolved = append(resolved, dependency)
+
+		// Exclusions declared on the entry for this dependency apply to its whole subtree
+		exclusions := entry.exclusions
+		for _, exclusion := range entry.target.Exclude {
+			exclusions = append(exclusions, activeExclusion{exclusion: exclusion, excludedBy: dependency})
+		}
+
+		expansionKey := entry.key + " " + exclusionsKey(exclusions)
+		if hasDependencies, ok := expanded[expansionKey]; ok {
+			dependency.Repeated = hasDependencies
+			continue
+		}
+
+		children := make([]*stackEntry, 0)
+		for _, scoped := range scopedDependencies(resolver, artifact.Package, entry.parent == nil) {
+			scope := scoped.scope
+			for _, child := range scoped.dependencies {
+				if excluded := findExclusion(exclusions, child); excluded != nil {
+					buildlog.Debugf("Excluding %s/%s from %s", child.Namespace, child.Name, entry.key)
+					dependency.Excluded = append(dependency.Excluded, &ExcludedDependency{
+						Requested:  child,
+						Scope:      scope,
+						Exclusion:  excluded.exclusion,
+						ExcludedBy: excluded.excludedBy,
+					})
+					continue
+				}
+
+				children = append(children, &stackEntry{
+					target:     child,
+					scope:      scope,
+					parent:     dependency,
+					exclusions: exclusions,
+				})
+			}
+		}
+
+		expanded[expansionKey] = len(children) > 0 || len(dependency.Excluded) > 0
+
+		// Push the children in reverse so they are visited in the order they were declared
+		for i := len(children) - 1; i >= 0; i-- {
+			stack = append(stack, children[i])
+		}
+	}
+
+	return resolved, nil
+}
+
+type scopedDependencyList struct {
+	scope        model.Scope
+	dependencies []model.Package
+}
+
+// scopedDependencies returns the dependencies the resolver follows from the package, grouped by scope.
+// The dependencies of resolvers that don't follow scopes have no scope
+func scopedDependencies(resolver DependencyResolver, target model.Package, root bool) []scopedDependencyList {
+	scopedResolver, ok := resolver.(ScopedDependencyResolver)
+	if !ok {
+		return []scopedDependencyList{{dependencies: resolver.GetDependencies(target)}}
+	}
+
+	lists := make([]scopedDependencyList, 0)
+	for _, scope := range scopedResolver.Scopes(root) {
+		lists = append(lists, scopedDependencyList{
+			scope:        scope,
+			dependencies: target.Dependencies.ForScope(scope),
+		})
+	}
+	return lists
+}
+
+// exclusionsKey identifies the packages excluded by the exclusions, regardless of where they were declared
+func exclusionsKey(exclusions []activeExclusion) string {
+	names := make([]string, len(exclusions))
+	for i, exclusion := range exclusions {
+		names[i] = exclusion.exclusion.String()
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func findExclusion(exclusions []activeExclusion, target model.Package) *activeExclusion {
+	for i := range exclusions {
+		if exclusions[i].exclusion.Matches(target) {
+			return &exclusions[i]
+		}
+	}
+	return nil
+}
+
+// DescribeDependencies returns a line for each resolved and excluded dependency, indented by its depth
+// in the dependency graph
+func DescribeDependencies(resolved []*ResolvedDependency) []string {
+	lines := make([]string, 0)
+	for _, dependency := range resolved {
+		indent := strings.Repeat("  ", dependency.Depth)
+		if dependency.Repeated {
+			lines = append(lines, indent+dependency.String()+" (dependencies listed above)")
+			continue
 		}
 
-		paths = append(paths, artifactLocation)
-		dependencies := resolver.GetDependencies(artifact.Package)
-		for _, dependency := range dependencies {
-			stack = append(stack, &stackEntry{target: dependency})
+		lines = append(lines, indent+dependency.String())
+		for _, excluded := range dependency.Excluded {
+			lines = append(lines, indent+"  "+excluded.String())
 		}
 	}
+	return lines
+}
+
+// BuildpathEntry is a single entry of a build path, along with the package and build it represents
+type BuildpathEntry struct {
+	Location string          // The location of the artifact in the local FS
+	Artifact *model.Artifact // The artifact at the location. Workspace packages have no build number
+	Origin   Origin          // Where the artifact came from
+}
+
+// OutputDir returns the directory containing the entry's build output
+func (b *BuildpathEntry) OutputDir() string {
+	return (&ResolvedDependency{Location: b.Location, Origin: b.Origin}).OutputDir()
+}
+
+// GetBuildpathEntries returns each artifact in the dependency closure of the target exactly once.
+//
+// The order is stable: the target always comes first, and every package comes before all of the
+// packages it depends on. Ties are broken by the order in which packages are first reached when
+// walking the dependencies depth first, in the order they are declared in the build files. For
+// example, if A depends on B and C, and both depend on D, the order is A, B, C, D.
+func GetBuildpathEntries(workspace string, target model.Package,
+	resolver DependencyResolver) ([]*BuildpathEntry, error) {
+	resolved, err := GetResolvedDependencies(workspace, target, resolver)
+	if err != nil {
+		return nil, err
+	}
+
+	return orderBuildpath(resolved), nil
+}
+
+// orderBuildpath de-duplicates the resolved dependencies and orders them topologically using Kahn's
+// algorithm, preferring the package that was reached first whenever there is a choice
+func orderBuildpath(resolved []*ResolvedDependency) []*BuildpathEntry {
+	keys := make([]string, 0)
+	nodes := make(map[string]*ResolvedDependency)
+	children := make(map[string][]string)
+	dependents := make(map[string]map[string]bool)
+	for _, dependency := range resolved {
+		key := packageToMapKey(dependency.Artifact.Package)
+		if _, ok := nodes[key]; !ok {
+			keys = append(keys, key)
+			nodes[key] = dependency
+			dependents[key] = make(map[string]bool)
+		}
+
+		if dependency.Parent != nil {
+			parentKey := packageToMapKey(dependency.Parent.Artifact.Package)
+			if !dependents[key][parentKey] {
+				dependents[key][parentKey] = true
+				children[parentKey] = append(children[parentKey], key)
+			}
+		}
+	}
+
+	entries := make([]*BuildpathEntry, 0, len(keys))
+	emitted := make(map[string]bool)
+	for len(entries) < len(keys) {
+		// The dependency graph has no cycles, so there is always a package whose dependents have all
+		// been emitted
+		for _, key := range keys {
+			if emitted[key] || len(dependents[key]) > 0 {
+				continue
+			}
+
+			emitted[key] = true
+			dependency := nodes[key]
+			entries = append(entries, &BuildpathEntry{
+				Location: dependency.Location,
+				Artifact: dependency.Artifact,
+				Origin:   dependency.Origin,
+			})
+
+			for _, child := range children[key] {
+				delete(dependents[child], key)
+			}
+			break
+		}
+	}
+
+	return entries
+}
+
+// GetToolPath returns the executable directories of the package's tool dependencies, suitable for
+// prepending to PATH
+func GetToolPath(workspace string, target model.Package) ([]string, error) {
+	entries, err := GetBuildpathEntries(workspace, target, ToolDependencyResolver)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0)
+	for _, entry := range entries[1:] {
+		paths = append(paths, filepath.Join(entry.OutputDir(), model.BinDir))
+	}
+
+	return paths, nil
+}
+
+// GetBuildpath returns a path to all packages required for the build, in the order described by
+// GetBuildpathEntries
+func GetBuildpath(workspace string, target model.Package, resolver DependencyResolver) ([]string, error) {
+	entries, err := GetBuildpathEntries(workspace, target, resolver)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, len(entries))
+	for i, entry := range entries {
+		paths[i] = entry.Location
+	}
 
 	return paths, nil
 }
@@ -184,7 +842,16 @@ func packageInfoToMapKey(namespace, name, version string) string {
 	return fmt.Sprintf("%s/%s/%s", namespace, name, version)
 }
 
+func packageNameKey(namespace, name string) string {
+	return fmt.Sprintf("%s/%s", namespace, name)
+}
+
 type stackEntry struct {
 	target  model.Package
+	scope   model.Scope
+	key     string              // The map key of the resolved target, set once the target has been resolved
+	parent  *ResolvedDependency // The resolved dependent that declared the target
 	visited bool
+
+	exclusions []activeExclusion // The exclusions inherited from the target's dependents
 }
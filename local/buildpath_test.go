@@ -0,0 +1,504 @@
+package local
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dimes/zbuild/model"
+)
+
+// testWorkspace is a workspace in a temporary directory. zbuild is offline, and every artifact of the
+// source set is in the package cache, so nothing is ever downloaded
+type testWorkspace struct {
+	dir             string
+	userDir         string
+	previousUserDir string
+}
+
+func newTestWorkspace(t *testing.T, sourceSet ...*model.Artifact) *testWorkspace {
+	dir, err := ioutil.TempDir("", "zbuild-workspace")
+	if err != nil {
+		t.Fatalf("Error creating workspace: %+v", err)
+	}
+
+	userDir, err := ioutil.TempDir("", "zbuild-home")
+	if err != nil {
+		t.Fatalf("Error creating user directory: %+v", err)
+	}
+
+	workspace := &testWorkspace{dir: dir, userDir: userDir, previousUserDir: os.Getenv(UserDirEnv)}
+	os.Setenv(UserDirEnv, userDir)
+
+	if err := os.MkdirAll(filepath.Join(dir, workspaceDirName), 0755); err != nil {
+		t.Fatalf("Error creating workspace directory: %+v", err)
+	}
+
+	config := []byte("offline: true\n")
+	if err := ioutil.WriteFile(filepath.Join(dir, workspaceDirName, workspaceConfigFileName), config,
+		0644); err != nil {
+		t.Fatalf("Error writing workspace config: %+v", err)
+	}
+
+	workspace.setSourceSet(t, sourceSet...)
+	return workspace
+}
+
+// setSourceSet replaces the artifacts of the workspace's source set
+func (w *testWorkspace) setSourceSet(t *testing.T, sourceSet ...*model.Artifact) {
+	metadata, err := json.Marshal(&WorkspaceMetadata{SourceSetName: "test", Artifacts: sourceSet})
+	if err != nil {
+		t.Fatalf("Error encoding workspace metadata: %+v", err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(w.dir, workspaceDirName, metadataFileName), metadata,
+		0644); err != nil {
+		t.Fatalf("Error writing workspace metadata: %+v", err)
+	}
+
+	for _, artifact := range sourceSet {
+		if err := os.MkdirAll(localArtifactCacheDir(w.dir, artifact), 0755); err != nil {
+			t.Fatalf("Error adding %s to the package cache: %+v", artifact.String(), err)
+		}
+	}
+}
+
+// addPackage checks out a package with the build file in the directory of the workspace
+func (w *testWorkspace) addPackage(t *testing.T, dir, buildfile string) model.Package {
+	packageDir := filepath.Join(w.dir, dir)
+	if err := os.MkdirAll(packageDir, 0755); err != nil {
+		t.Fatalf("Error creating %s: %+v", packageDir, err)
+	}
+
+	buildfilePath := filepath.Join(packageDir, model.BuildfileName)
+	if err := ioutil.WriteFile(buildfilePath, []byte(buildfile), 0644); err != nil {
+		t.Fatalf("Error writing %s: %+v", buildfilePath, err)
+	}
+
+	parsedBuildfile, err := model.ParseBuildfile(buildfilePath)
+	if err != nil {
+		t.Fatalf("Error parsing %s: %+v", buildfilePath, err)
+	}
+	return parsedBuildfile.Package
+}
+
+func (w *testWorkspace) close() {
+	os.Setenv(UserDirEnv, w.previousUserDir)
+	os.RemoveAll(w.dir)
+	os.RemoveAll(w.userDir)
+}
+
+// testArtifact returns build 1 of the referenced package, e.g. ns/lib@1.0, with the given compile
+// dependencies. Dependencies are qualified like the dependencies in build files
+func testArtifact(t *testing.T, reference string, compile ...string) *model.Artifact {
+	pkg, err := model.ParseReference(reference)
+	if err != nil {
+		t.Fatalf("Invalid reference %s: %+v", reference, err)
+	}
+
+	for _, dependencyReference := range compile {
+		dependency, err := model.ParseReference(dependencyReference)
+		if err != nil {
+			t.Fatalf("Invalid reference %s: %+v", dependencyReference, err)
+		}
+		pkg.Dependencies.Compile = append(pkg.Dependencies.Compile, pkg.Qualify(dependency))
+	}
+
+	return model.NewArtifact(pkg, "1")
+}
+
+func resolvedKeys(resolved []*ResolvedDependency) []string {
+	keys := make([]string, len(resolved))
+	for i, dependency := range resolved {
+		keys[i] = packageToMapKey(dependency.Artifact.Package)
+	}
+	return keys
+}
+
+func TestResolveConflicts(t *testing.T) {
+	tests := []struct {
+		name       string
+		resolution string
+		compile    []string
+		expected   []string
+		err        string
+	}{
+		{
+			name:     "the same version on both sides of a diamond isn't a conflict",
+			compile:  []string{"a@1.0", "c@1.0"},
+			expected: []string{"ns/app/1.0", "ns/a/1.0", "ns/lib/1.0", "ns/c/1.0", "ns/lib/1.0"},
+		},
+		{
+			name:    "two versions fail by default",
+			compile: []string{"a@1.0", "b@1.0"},
+			err:     "Found conflicting versions of ns/lib",
+		},
+		{
+			name:       "two versions fail with the fail policy",
+			resolution: "conflicts: fail",
+			compile:    []string{"a@1.0", "b@1.0"},
+			err:        "Found conflicting versions of ns/lib",
+		},
+		{
+			name:       "the newest policy uses the newest version everywhere",
+			resolution: "conflicts: newest",
+			compile:    []string{"a@1.0", "b@1.0"},
+			expected:   []string{"ns/app/1.0", "ns/a/1.0", "ns/lib/2.0", "ns/b/1.0", "ns/lib/2.0"},
+		},
+		{
+			name:       "pins take precedence over the conflict policy",
+			resolution: "pins: [lib@1.0]",
+			compile:    []string{"a@1.0", "b@1.0"},
+			expected:   []string{"ns/app/1.0", "ns/a/1.0", "ns/lib/1.0", "ns/b/1.0", "ns/lib/1.0"},
+		},
+		{
+			name:       "pins may be constraints",
+			resolution: "pins: [\"lib@^1\"]",
+			compile:    []string{"b@1.0"},
+			expected:   []string{"ns/app/1.0", "ns/b/1.0", "ns/lib/1.0"},
+		},
+		{
+			name:       "the newest policy fails if a dependency requires another version of the root",
+			resolution: "conflicts: newest",
+			compile:    []string{"d@1.0"},
+			err:        "ns/app depends on another version of itself",
+		},
+		{
+			name:       "unknown policies fail",
+			resolution: "conflicts: oldest",
+			compile:    []string{"a@1.0"},
+			err:        "Unknown conflict policy oldest",
+		},
+	}
+
+	sourceSet := []*model.Artifact{
+		testArtifact(t, "ns/a@1.0", "lib@1.0"),
+		testArtifact(t, "ns/b@1.0", "lib@2.0"),
+		testArtifact(t, "ns/c@1.0", "lib@1.0"),
+		testArtifact(t, "ns/d@1.0", "app@2.0"),
+		testArtifact(t, "ns/app@2.0"),
+		testArtifact(t, "ns/lib@1.0"),
+		testArtifact(t, "ns/lib@2.0"),
+	}
+
+	for _, test := range tests {
+		workspace := newTestWorkspace(t, sourceSet...)
+		target := workspace.addPackage(t, "app", "namespace: ns\nname: app\nversion: \"1.0\"\ntype: go\n"+
+			"dependencies:\n  compile: ["+strings.Join(test.compile, ", ")+"]\n"+
+			"resolution: {"+test.resolution+"}\n")
+
+		resolved, err := GetResolvedDependencies(workspace.dir, target, CompileDependencyResolver)
+		workspace.close()
+
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: expected an error containing %q, got %+v", test.name, test.err, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %+v", test.name, err)
+			continue
+		}
+
+		if actual := resolvedKeys(resolved); !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%s: resolved %v, expected %v", test.name, actual, test.expected)
+		}
+	}
+}
+
+func TestFindConflicts(t *testing.T) {
+	sourceSet := []*model.Artifact{
+		testArtifact(t, "ns/a@1.0", "lib@1.0"),
+		testArtifact(t, "ns/b@1.0", "lib@2.0", "other@1.0"),
+		testArtifact(t, "ns/lib@1.0"),
+		testArtifact(t, "ns/lib@2.0"),
+		testArtifact(t, "ns/other@1.0"),
+	}
+
+	workspace := newTestWorkspace(t, sourceSet...)
+	defer workspace.close()
+	target := workspace.addPackage(t, "app", "namespace: ns\nname: app\nversion: \"1.0\"\ntype: go\n"+
+		"dependencies:\n  compile: [a@1.0, b@1.0]\n")
+
+	resolved, err := WalkDependencies(workspace.dir, target, CompileDependencyResolver)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	conflicts := findConflicts(resolved)
+	if len(conflicts) != 1 {
+		t.Fatalf("Found %d conflicts, expected 1", len(conflicts))
+	}
+
+	conflict := conflicts[0]
+	if conflict.namespace != "ns" || conflict.name != "lib" ||
+		!reflect.DeepEqual(conflict.versions, []string{"1.0", "2.0"}) || conflict.newest() != "2.0" {
+		t.Errorf("Unexpected conflict %s/%s %v", conflict.namespace, conflict.name, conflict.versions)
+	}
+
+	for version, path := range map[string]string{
+		"1.0": "ns/app/1.0 -> ns/a/1.0 -> ns/lib/1.0",
+		"2.0": "ns/app/1.0 -> ns/b/1.0 -> ns/lib/2.0",
+	} {
+		if paths := conflict.paths[version]; len(paths) != 1 || paths[0].Path() != path {
+			t.Errorf("Unexpected paths to version %s: %v", version, resolvedKeys(paths))
+		}
+	}
+}
+
+func TestWalkRepeatedDependencies(t *testing.T) {
+	// Each level is a diamond, so there are 2^levels paths to the last package
+	const levels = 24
+	sourceSet := make([]*model.Artifact, 0)
+	for level := 0; level < levels; level++ {
+		next := fmt.Sprintf("x%d@1.0", level+1)
+		sourceSet = append(sourceSet,
+			testArtifact(t, fmt.Sprintf("ns/x%d@1.0", level), fmt.Sprintf("a%d@1.0", level),
+				fmt.Sprintf("b%d@1.0", level)),
+			testArtifact(t, fmt.Sprintf("ns/a%d@1.0", level), next),
+			testArtifact(t, fmt.Sprintf("ns/b%d@1.0", level), next))
+	}
+	sourceSet = append(sourceSet, testArtifact(t, fmt.Sprintf("ns/x%d@1.0", levels)))
+
+	workspace := newTestWorkspace(t, sourceSet...)
+	defer workspace.close()
+	target := workspace.addPackage(t, "app", "namespace: ns\nname: app\nversion: \"1.0\"\ntype: go\n"+
+		"dependencies:\n  compile: [x0@1.0]\n")
+
+	resolved, err := GetResolvedDependencies(workspace.dir, target, CompileDependencyResolver)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	// Every package is listed once with its dependencies. Each x is listed again under the b of the
+	// level above, but only the last x has no dependencies to leave out
+	if len(resolved) != 4*levels+2 {
+		t.Fatalf("Resolved %d dependencies, expected %d", len(resolved), 4*levels+2)
+	}
+
+	repeated := make([]string, 0)
+	for _, dependency := range resolved {
+		if dependency.Repeated {
+			repeated = append(repeated, dependency.Path())
+		}
+	}
+
+	if len(repeated) != levels-1 ||
+		repeated[len(repeated)-1] != "ns/app/1.0 -> ns/x0/1.0 -> ns/b0/1.0 -> ns/x1/1.0" {
+		t.Errorf("Unexpected repeated dependencies %v", repeated)
+	}
+
+	entries := orderBuildpath(resolved)
+	if len(entries) != 3*levels+2 || packageToMapKey(entries[len(entries)-1].Artifact.Package) !=
+		fmt.Sprintf("ns/x%d/1.0", levels) {
+		t.Errorf("Unexpected buildpath with %d entries", len(entries))
+	}
+}
+
+func TestWalkRepeatedDependenciesWithExclusions(t *testing.T) {
+	sourceSet := []*model.Artifact{
+		testArtifact(t, "ns/lib@1.0", "util@1.0"),
+		testArtifact(t, "ns/util@1.0"),
+	}
+
+	workspace := newTestWorkspace(t, sourceSet...)
+	defer workspace.close()
+	target := workspace.addPackage(t, "app", `namespace: ns
+name: app
+version: "1.0"
+type: go
+dependencies:
+  compile:
+    - lib@1.0
+    - name: lib
+      version: "1.0"
+      exclude: [{name: util}]
+    - lib@1.0
+`)
+
+	resolved, err := GetResolvedDependencies(workspace.dir, target, CompileDependencyResolver)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	// The exclusion changes the dependencies of lib, so they are walked again
+	expected := []string{"ns/app/1.0", "ns/lib/1.0", "ns/util/1.0", "ns/lib/1.0", "ns/lib/1.0"}
+	if actual := resolvedKeys(resolved); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Resolved %v, expected %v", actual, expected)
+	}
+
+	if resolved[1].Repeated || resolved[3].Repeated || len(resolved[3].Excluded) != 1 || !resolved[4].Repeated {
+		t.Errorf("Expected only the last lib to be repeated, and the second one to exclude util")
+	}
+}
+
+func TestBuildRun(t *testing.T) {
+	workspace := newTestWorkspace(t, testArtifact(t, "ns/lib@1.0"))
+	defer workspace.close()
+	defer func() { currentBuildRun = nil }()
+	target := workspace.addPackage(t, "app", "namespace: ns\nname: app\nversion: \"1.0\"\ntype: go\n"+
+		"dependencies:\n  compile: [lib@1.0]\n")
+
+	StartBuildRun()
+	first, err := GetResolvedDependencies(workspace.dir, target, CompileDependencyResolver)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	// Changes to the source set aren't seen until the next run
+	workspace.setSourceSet(t, withBuild(testArtifact(t, "ns/lib@1.0"), "2", ""))
+	second, err := GetResolvedDependencies(workspace.dir, target, CompileDependencyResolver)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	if &first[0] != &second[0] {
+		t.Errorf("The closure was resolved twice in the same build run")
+	}
+
+	walked, err := WalkDependencies(workspace.dir, target, CompileDependencyResolver)
+	if err != nil || &walked[0] == &first[0] {
+		t.Errorf("Walking the dependencies reused the resolution (%+v)", err)
+	}
+
+	StartBuildRun()
+	third, err := GetResolvedDependencies(workspace.dir, target, CompileDependencyResolver)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	if third[1].Artifact.BuildNumber != "2" {
+		t.Errorf("Resolved build %s in a new build run, expected 2", third[1].Artifact.BuildNumber)
+	}
+}
+
+func TestGetBuildpathEntries(t *testing.T) {
+	tests := []struct {
+		name     string
+		compile  []string
+		expected []string
+	}{
+		{
+			name:     "a diamond lists the shared dependency once, after both dependents",
+			compile:  []string{"b@1.0", "c@1.0"},
+			expected: []string{"ns/app/1.0", "ns/b/1.0", "ns/c/1.0", "ns/d/1.0"},
+		},
+		{
+			name:     "dependencies come after every dependent, even if they were reached first",
+			compile:  []string{"d@1.0", "c@1.0"},
+			expected: []string{"ns/app/1.0", "ns/c/1.0", "ns/d/1.0"},
+		},
+		{
+			name:     "ties are broken by the declaration order",
+			compile:  []string{"c@1.0", "b@1.0"},
+			expected: []string{"ns/app/1.0", "ns/c/1.0", "ns/b/1.0", "ns/d/1.0"},
+		},
+	}
+
+	sourceSet := []*model.Artifact{
+		testArtifact(t, "ns/b@1.0", "d@1.0"),
+		testArtifact(t, "ns/c@1.0", "d@1.0"),
+		testArtifact(t, "ns/d@1.0"),
+	}
+
+	for _, test := range tests {
+		workspace := newTestWorkspace(t, sourceSet...)
+		target := workspace.addPackage(t, "app", "namespace: ns\nname: app\nversion: \"1.0\"\ntype: go\n"+
+			"dependencies:\n  compile: ["+strings.Join(test.compile, ", ")+"]\n")
+
+		entries, err := GetBuildpathEntries(workspace.dir, target, CompileDependencyResolver)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %+v", test.name, err)
+			workspace.close()
+			continue
+		}
+
+		actual := make([]string, len(entries))
+		for i, entry := range entries {
+			actual[i] = packageToMapKey(entry.Artifact.Package)
+		}
+
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%s: buildpath %v, expected %v", test.name, actual, test.expected)
+		}
+
+		root := entries[0]
+		if root.Origin != OriginWorkspace || root.Location != filepath.Join(workspace.dir, "app") {
+			t.Errorf("%s: the root entry is %s in %s, expected the workspace package", test.name, root.Origin,
+				root.Location)
+		}
+
+		for _, entry := range entries[1:] {
+			if entry.Origin != OriginSourceSet || entry.Artifact.BuildNumber != "1" ||
+				entry.Location != localArtifactCacheDir(workspace.dir, entry.Artifact) {
+				t.Errorf("%s: unexpected entry for %s: build %s from %s in %s", test.name,
+					entry.Artifact.String(), entry.Artifact.BuildNumber, entry.Origin, entry.Location)
+			}
+		}
+		workspace.close()
+	}
+}
+
+func TestResolveShorthandReferences(t *testing.T) {
+	tests := []struct {
+		name     string
+		compile  []string
+		expected []string
+		valid    bool
+	}{
+		{
+			name:     "the namespace defaults to the package's namespace",
+			compile:  []string{"lib@1.0"},
+			expected: []string{"ns/app/1.0", "ns/lib/1.0"},
+			valid:    true,
+		},
+		{
+			name:     "an omitted version resolves to the highest version in use",
+			compile:  []string{"lib", "other/util"},
+			expected: []string{"ns/app/1.0", "ns/lib/1.2", "other/util/3.0"},
+			valid:    true,
+		},
+		{
+			name:     "an omitted version of a package the source set doesn't use",
+			compile:  []string{"other/missing"},
+			valid:    false,
+		},
+	}
+
+	sourceSet := []*model.Artifact{
+		testArtifact(t, "ns/lib@1.0"),
+		testArtifact(t, "ns/lib@1.2"),
+		testArtifact(t, "other/util@3.0"),
+	}
+
+	for _, test := range tests {
+		workspace := newTestWorkspace(t, sourceSet...)
+		target := workspace.addPackage(t, "app", "namespace: ns\nname: app\nversion: \"1.0\"\ntype: go\n"+
+			"dependencies:\n  compile: ["+strings.Join(test.compile, ", ")+"]\n")
+
+		resolved, err := GetResolvedDependencies(workspace.dir, target, CompileDependencyResolver)
+		workspace.close()
+		if !test.valid {
+			if err == nil {
+				t.Errorf("%s: expected an error, resolved %v", test.name, resolvedKeys(resolved))
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %+v", test.name, err)
+			continue
+		}
+
+		if actual := resolvedKeys(resolved); !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%s: resolved %v, expected %v", test.name, actual, test.expected)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package local
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/dimes/zbuild/model"
+)
+
+var (
+	// buildResolvers are the closures whose workspace packages must be built before a package. Builders
+	// may package the runtime closure with the executables they build
+	buildResolvers = []DependencyResolver{CompileDependencyResolver, ToolDependencyResolver,
+		RuntimeDependencyResolver}
+)
+
+// PlannedBuild is a workspace package to build, along with the planned builds of the workspace
+// packages it depends on. Err is set if the package's dependencies couldn't be resolved, in which case
+// it can't be built
+type PlannedBuild struct {
+	Buildfile    *model.ParsedBuildfile
+	Dependencies []*PlannedBuild
+	Err          error
+}
+
+// PlanWorkspaceBuild returns the builds of the targets in topological order, i.e. every package comes
+// after the workspace packages it depends on. If withDeps is set, the workspace packages the targets
+// depend on are built too. Otherwise, their existing build output is used as is.
+//
+// The closures of every planned package are resolved, so any artifacts they need are downloaded
+// before the first build starts. A package whose closure can't be resolved is still planned, with
+// its Err set
+func PlanWorkspaceBuild(workspace string, targets []*model.ParsedBuildfile,
+	withDeps bool) ([]*PlannedBuild, error) {
+	workspacePackages, err := findWorkspacePackages(workspace)
+	if err != nil {
+		return nil, err
+	}
+
+	packagesByLocation := make(map[string]*model.ParsedBuildfile)
+	for _, parsedBuildfile := range workspacePackages {
+		packagesByLocation[parsedBuildfile.AbsoluteWorkingDir] = parsedBuildfile
+	}
+
+	planned := make(map[string]*PlannedBuild)
+	queue := make([]*PlannedBuild, 0, len(targets))
+	for _, target := range targets {
+		if planned[target.AbsoluteWorkingDir] == nil {
+			build := &PlannedBuild{Buildfile: target}
+			planned[target.AbsoluteWorkingDir] = build
+			queue = append(queue, build)
+		}
+	}
+
+	dependencyLocations := make(map[*PlannedBuild][]string)
+	for i := 0; i < len(queue); i++ {
+		build := queue[i]
+		locations, err := getWorkspaceDependencies(workspace, build.Buildfile)
+		if err != nil {
+			build.Err = err
+			continue
+		}
+		dependencyLocations[build] = locations
+
+		for _, location := range locations {
+			if planned[location] != nil || !withDeps {
+				continue
+			}
+
+			parsedBuildfile := packagesByLocation[location]
+			if parsedBuildfile == nil {
+				return nil, fmt.Errorf("%s resolved to %s, which isn't a workspace package",
+					build.Buildfile.Package.String(), location)
+			}
+
+			dependency := &PlannedBuild{Buildfile: parsedBuildfile}
+			planned[location] = dependency
+			queue = append(queue, dependency)
+		}
+	}
+
+	for _, build := range queue {
+		for _, location := range dependencyLocations[build] {
+			if dependency := planned[location]; dependency != nil {
+				build.Dependencies = append(build.Dependencies, dependency)
+			}
+		}
+	}
+
+	return orderPlannedBuilds(queue)
+}
+
+// getWorkspaceDependencies returns the locations of the workspace packages in the build closures of
+// the package
+func getWorkspaceDependencies(workspace string, parsedBuildfile *model.ParsedBuildfile) ([]string, error) {
+	seen := make(map[string]bool)
+	locations := make([]string, 0)
+	for _, resolver := range buildResolvers {
+		resolved, err := GetResolvedDependencies(workspace, parsedBuildfile.Package, resolver)
+		if err != nil {
+			return nil, fmt.Errorf("Error resolving dependencies of %s: %+v", parsedBuildfile.Package.String(), err)
+		}
+
+		for _, dependency := range resolved[1:] {
+			if dependency.Origin != OriginWorkspace || seen[dependency.Location] {
+				continue
+			}
+			seen[dependency.Location] = true
+			locations = append(locations, dependency.Location)
+		}
+	}
+
+	return locations, nil
+}
+
+// orderPlannedBuilds sorts the builds so that dependencies come first. Ties are broken by location
+func orderPlannedBuilds(builds []*PlannedBuild) ([]*PlannedBuild, error) {
+	sort.Slice(builds, func(i, j int) bool {
+		return builds[i].Buildfile.AbsoluteWorkingDir < builds[j].Buildfile.AbsoluteWorkingDir
+	})
+
+	remaining := make(map[*PlannedBuild]int)
+	dependents := make(map[*PlannedBuild][]*PlannedBuild)
+	for _, build := range builds {
+		remaining[build] = len(build.Dependencies)
+		for _, dependency := range build.Dependencies {
+			dependents[dependency] = append(dependents[dependency], build)
+		}
+	}
+
+	ordered := make([]*PlannedBuild, 0, len(builds))
+	for len(ordered) < len(builds) {
+		progress := false
+		for _, build := range builds {
+			if remaining[build] != 0 {
+				continue
+			}
+
+			progress = true
+			remaining[build] = -1
+			ordered = append(ordered, build)
+			for _, dependent := range dependents[build] {
+				remaining[dependent]--
+			}
+		}
+
+		if !progress {
+			cycle := make([]string, 0)
+			for _, build := range builds {
+				if remaining[build] > 0 {
+					cycle = append(cycle, build.Buildfile.Package.String())
+				}
+			}
+			return nil, fmt.Errorf("Workspace packages depend on each other: %s", strings.Join(cycle, ", "))
+		}
+	}
+
+	return ordered, nil
+}
+
+// SelectDownstream returns the part of the plan that must be rebuilt when the packages in the changed
+// directories change, i.e. those packages and every planned package that depends on them. The
+// returned builds are in plan order and only depend on each other
+func SelectDownstream(plan []*PlannedBuild, changed map[string]bool) []*PlannedBuild {
+	selected := make(map[*PlannedBuild]*PlannedBuild)
+	selection := make([]*PlannedBuild, 0)
+	for _, build := range plan {
+		include := changed[build.Buildfile.AbsoluteWorkingDir]
+		for _, dependency := range build.Dependencies {
+			include = include || selected[dependency] != nil
+		}
+
+		if !include {
+			continue
+		}
+
+		copied := &PlannedBuild{Buildfile: build.Buildfile, Err: build.Err}
+		for _, dependency := range build.Dependencies {
+			if selectedDependency := selected[dependency]; selectedDependency != nil {
+				copied.Dependencies = append(copied.Dependencies, selectedDependency)
+			}
+		}
+
+		selected[build] = copied
+		selection = append(selection, copied)
+	}
+
+	return selection
+}
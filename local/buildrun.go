@@ -0,0 +1,134 @@
+package local
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/dimes/zbuild/model"
+)
+
+var (
+	// currentBuildRun is the build run in progress. It is nil outside of build runs, where every
+	// closure is resolved from scratch
+	currentBuildRun     *buildRun
+	currentBuildRunLock sync.Mutex
+)
+
+// buildRun shares dependency resolution between all builds of a run. Planning, fingerprinting,
+// setting up the environment of and building a package all need its closures, and each closure is
+// only resolved once per run
+type buildRun struct {
+	lock       sync.Mutex
+	generators map[string]*buildpathGenerator // Keyed by the path the generator was created for
+	closures   map[closureKey]*resolvedClosure
+}
+
+// closureKey identifies a resolution of a package's dependency closure
+type closureKey struct {
+	workspace         string
+	target            string
+	resolver          DependencyResolver
+	tolerateConflicts bool
+}
+
+// resolvedClosure is the outcome of a resolution. It is resolved by whichever build needs it first,
+// while the other builds wait
+type resolvedClosure struct {
+	once     sync.Once
+	resolved []*ResolvedDependency
+	err      error
+}
+
+// StartBuildRun starts a new build run, e.g. zbuild build or one round of rebuilds in zbuild watch.
+// Until the next run starts, each dependency closure is resolved once, and changes to build files or
+// the source set made in the meantime are not seen. The artifacts pinned by the previous run may be
+// pruned from the package cache again
+func StartBuildRun() {
+	currentBuildRunLock.Lock()
+	currentBuildRun = &buildRun{
+		generators: make(map[string]*buildpathGenerator),
+		closures:   make(map[closureKey]*resolvedClosure),
+	}
+	currentBuildRunLock.Unlock()
+
+	pinnedArtifactsLock.Lock()
+	pinnedArtifacts = make(map[string]bool)
+	pinnedArtifactsLock.Unlock()
+}
+
+func getBuildRun() *buildRun {
+	currentBuildRunLock.Lock()
+	defer currentBuildRunLock.Unlock()
+	return currentBuildRun
+}
+
+// getBuildpathGenerator returns a generator for the workspace containing the path. During a build
+// run, generators share their source sets and managers, so e.g. the remote manager is created once
+func getBuildpathGenerator(path string) (*buildpathGenerator, error) {
+	run := getBuildRun()
+	if run == nil {
+		return newBuildpathGenerator(path)
+	}
+
+	run.lock.Lock()
+	defer run.lock.Unlock()
+
+	shared, ok := run.generators[path]
+	if !ok {
+		var err error
+		if shared, err = newBuildpathGenerator(path); err != nil {
+			return nil, err
+		}
+		run.generators[path] = shared
+	}
+
+	// The state of a single resolution is never shared
+	generator := *shared
+	generator.missing = make(map[string]*model.Artifact)
+	return &generator, nil
+}
+
+// resolveClosure resolves the dependency closure of the target, honoring its lock file. During a
+// build run, the closure is only resolved the first time, and the same dependencies are returned
+// every time after that. They must not be modified
+func resolveClosure(workspace string, target model.Package, resolver DependencyResolver,
+	tolerateConflicts bool) ([]*ResolvedDependency, error) {
+	run := getBuildRun()
+	if run == nil {
+		return resolveNewClosure(workspace, target, resolver, tolerateConflicts)
+	}
+
+	key := closureKey{
+		workspace:         workspace,
+		target:            fmt.Sprintf("%s %+v %+v", packageToMapKey(target), target.Resolution, target.Exclude),
+		resolver:          resolver,
+		tolerateConflicts: tolerateConflicts,
+	}
+
+	run.lock.Lock()
+	closure, ok := run.closures[key]
+	if !ok {
+		closure = &resolvedClosure{}
+		run.closures[key] = closure
+	}
+	run.lock.Unlock()
+
+	closure.once.Do(func() {
+		closure.resolved, closure.err = resolveNewClosure(workspace, target, resolver, tolerateConflicts)
+	})
+	return closure.resolved, closure.err
+}
+
+func resolveNewClosure(workspace string, target model.Package, resolver DependencyResolver,
+	tolerateConflicts bool) ([]*ResolvedDependency, error) {
+	buildpathGenerator, err := getBuildpathGenerator(workspace)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting buildpath generator for %s: %+v", workspace, err)
+	}
+
+	if err := buildpathGenerator.useLockfile(target); err != nil {
+		return nil, err
+	}
+
+	return buildpathGenerator.resolve(target, resolver, tolerateConflicts)
+}
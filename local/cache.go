@@ -0,0 +1,150 @@
+package local
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dimes/zbuild/buildlog"
+)
+
+var (
+	// pinnedArtifacts are the package cache locations of every artifact resolved during the current
+	// build run, e.g. the closures of all packages in a build plan. They are not pruned, so resolving
+	// one closure can't remove an artifact another closure is about to be built against. Outside of
+	// build runs, they are the artifacts resolved by this process
+	pinnedArtifacts     = make(map[string]bool)
+	pinnedArtifactsLock sync.Mutex
+
+	// downloadLocks serialize the downloads of each artifact, so concurrent resolutions that need the
+	// same artifact wait for a single download instead of extracting it at the same time
+	downloadLocks     = make(map[string]*sync.Mutex)
+	downloadLocksLock sync.Mutex
+)
+
+// cachedArtifact is a build of a package in the workspace package cache
+type cachedArtifact struct {
+	location string
+	size     int64
+	lastUsed time.Time
+}
+
+// markCacheUse records that the cached artifact at the location was used, so it is pruned last
+func markCacheUse(location string) {
+	now := time.Now()
+	if err := os.Chtimes(location, now, now); err != nil {
+		buildlog.Debugf("Error marking %s as used: %+v", location, err)
+	}
+}
+
+// pinCachedArtifact prevents the cached artifact at the location from being pruned until the next
+// build run starts
+func pinCachedArtifact(location string) {
+	pinnedArtifactsLock.Lock()
+	defer pinnedArtifactsLock.Unlock()
+	pinnedArtifacts[location] = true
+}
+
+// lockDownload locks the download of the artifact at the location in the package cache, and returns a
+// function that unlocks it
+func lockDownload(location string) func() {
+	downloadLocksLock.Lock()
+	lock := downloadLocks[location]
+	if lock == nil {
+		lock = &sync.Mutex{}
+		downloadLocks[location] = lock
+	}
+	downloadLocksLock.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+// pruneCache removes the least recently used artifacts from the package cache until it fits in the
+// configured cache size. Pinned artifacts are never removed, even if they alone exceed it
+func (b *buildpathGenerator) pruneCache() {
+	pinnedArtifactsLock.Lock()
+	defer pinnedArtifactsLock.Unlock()
+
+	cached, err := listCachedArtifacts(b.workspace)
+	if err != nil {
+		buildlog.Warningf("Error listing the package cache: %+v", err)
+		return
+	}
+
+	total := int64(0)
+	for _, artifact := range cached {
+		total += artifact.size
+	}
+
+	sort.Slice(cached, func(i, j int) bool {
+		return cached[i].lastUsed.Before(cached[j].lastUsed)
+	})
+
+	for _, artifact := range cached {
+		if total <= b.cacheSize {
+			return
+		}
+
+		if pinnedArtifacts[artifact.location] {
+			continue
+		}
+
+		buildlog.Debugf("Removing %s from the package cache", artifact.location)
+		if err := os.RemoveAll(artifact.location); err != nil {
+			buildlog.Warningf("Error removing %s from the package cache: %+v", artifact.location, err)
+			continue
+		}
+		total -= artifact.size
+	}
+
+	if total > b.cacheSize {
+		buildlog.Warningf("The package cache uses %d bytes, which is more than the configured cache "+
+			"size, because the remaining artifacts are in use", total)
+	}
+}
+
+// listCachedArtifacts returns every artifact in the package cache, which is laid out as
+// namespace/name/version/build. Artifacts that are still being extracted are hidden, and not listed
+func listCachedArtifacts(workspace string) ([]*cachedArtifact, error) {
+	cacheDir := filepath.Join(workspace, workspaceDirName, workspacePackageCacheDirName)
+	locations, err := filepath.Glob(filepath.Join(cacheDir, "*", "*", "*", "*"))
+	if err != nil {
+		return nil, err
+	}
+
+	cached := make([]*cachedArtifact, 0, len(locations))
+	for _, location := range locations {
+		if strings.HasPrefix(filepath.Base(location), ".") {
+			continue
+		}
+
+		info, err := os.Stat(location)
+		if err != nil {
+			return nil, err
+		}
+
+		size := int64(0)
+		err = filepath.Walk(location, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		cached = append(cached, &cachedArtifact{
+			location: location,
+			size:     size,
+			lastUsed: info.ModTime(),
+		})
+	}
+
+	return cached, nil
+}
@@ -0,0 +1,57 @@
+package local
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dimes/zbuild/model"
+)
+
+func TestPruneCache(t *testing.T) {
+	old := testArtifact(t, "ns/old@1.0")
+	current := testArtifact(t, "ns/current@1.0")
+	workspace := newTestWorkspace(t, old, current)
+	defer workspace.close()
+	defer func() { currentBuildRun = nil }()
+
+	// Both artifacts are larger than the cache, and the old one was used least recently
+	for i, artifact := range []*model.Artifact{old, current} {
+		location := localArtifactCacheDir(workspace.dir, artifact)
+		if err := ioutil.WriteFile(filepath.Join(location, "data"), make([]byte, 100), 0644); err != nil {
+			t.Fatalf("Error writing %s: %+v", location, err)
+		}
+
+		used := time.Now().Add(time.Duration(i-2) * time.Hour)
+		if err := os.Chtimes(location, used, used); err != nil {
+			t.Fatalf("Error setting the modification time of %s: %+v", location, err)
+		}
+	}
+
+	generator := &buildpathGenerator{workspace: workspace.dir, cacheSize: 50}
+	StartBuildRun()
+	pinCachedArtifact(localArtifactCacheDir(workspace.dir, old))
+	pinCachedArtifact(localArtifactCacheDir(workspace.dir, current))
+	generator.pruneCache()
+
+	for _, artifact := range []*model.Artifact{old, current} {
+		if _, err := os.Stat(localArtifactCacheDir(workspace.dir, artifact)); err != nil {
+			t.Errorf("Pruned %s, which is pinned by the build run", artifact.String())
+		}
+	}
+
+	// Only the artifacts used by the new run are kept
+	StartBuildRun()
+	pinCachedArtifact(localArtifactCacheDir(workspace.dir, current))
+	generator.pruneCache()
+
+	if _, err := os.Stat(localArtifactCacheDir(workspace.dir, old)); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to be pruned after the build run that used it (%+v)", old.String(), err)
+	}
+
+	if _, err := os.Stat(localArtifactCacheDir(workspace.dir, current)); err != nil {
+		t.Errorf("Pruned %s, which is pinned by the build run", current.String())
+	}
+}
@@ -0,0 +1,519 @@
+package local
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dimes/zbuild/buildlog"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	workspaceConfigFileName = "workspace.yaml"
+
+	defaultPackageMaxDepth = 3
+
+	// defaultBuildCacheSize is the size limit of build caches on disk if buildCacheSize isn't set
+	defaultBuildCacheSize = "10GB"
+)
+
+var (
+	// ErrOffline is returned when a remote source set or manager is requested while offline
+	ErrOffline = errors.New("zbuild is offline")
+
+	offline = false
+
+	sizeUnits = map[string]int64{
+		"":   1,
+		"B":  1,
+		"K":  1 << 10,
+		"KB": 1 << 10,
+		"M":  1 << 20,
+		"MB": 1 << 20,
+		"G":  1 << 30,
+		"GB": 1 << 30,
+		"T":  1 << 40,
+		"TB": 1 << 40,
+	}
+)
+
+// WorkspaceConfig contains the user editable settings of a workspace. It is stored in
+// .workspace/workspace.yaml
+type WorkspaceConfig struct {
+	// LogLevel is the default log level, i.e. debug, info, warning or error
+	LogLevel string `yaml:"logLevel,omitempty"`
+
+	// Offline prevents the workspace from contacting its remote source set and manager. Dependencies
+	// are only resolved from packages checked out in the workspace, the local repository and the
+	// package cache
+	Offline bool `yaml:"offline,omitempty"`
+
+	// CacheSize limits the size of the package cache, e.g. 10GB. The least recently used artifacts
+	// are removed when the limit is exceeded. The package cache is unlimited if this is empty
+	CacheSize string `yaml:"cacheSize,omitempty"`
+
+	// Parallelism is the number of packages built at once. It defaults to the number of CPUs
+	Parallelism int `yaml:"parallelism,omitempty"`
+
+	// DefaultResolver is the dependency resolver used by commands when -resolver isn't passed
+	DefaultResolver string `yaml:"defaultResolver,omitempty"`
+
+	// PackageRoots are directories, relative to the workspace, that contain packages in addition to
+	// the workspace itself
+	PackageRoots []string `yaml:"packageRoots,omitempty"`
+
+	// PackageMaxDepth is how many directories below a package root are searched for packages. A
+	// depth of 1 only searches the direct children. It defaults to 3
+	PackageMaxDepth int `yaml:"packageMaxDepth,omitempty"`
+
+	// PackageIgnore are glob patterns of directories that are never searched for packages. Patterns
+	// are matched against the directory's name and its path relative to the workspace
+	PackageIgnore []string `yaml:"packageIgnore,omitempty"`
+
+	// BuildCache is where build outputs are cached, keyed by their fingerprint, platform, toolchain and
+	// host environment: local for the user's zbuild directory, remote for the workspace's remote
+	// manager, or an absolute directory. Build outputs aren't cached if this is empty
+	BuildCache string `yaml:"buildCache,omitempty"`
+
+	// BuildCacheSize limits the size of a local or directory build cache, e.g. 20GB. The least recently
+	// used outputs are removed when the limit is exceeded. It defaults to 10GB, and 0 removes the limit.
+	// The remote build cache isn't limited by zbuild
+	BuildCacheSize string `yaml:"buildCacheSize,omitempty"`
+
+	// Hermetic builds every package in a sandbox that only contains its sources, its resolved
+	// dependencies and the sandbox paths. Builds have no network access. Requires Linux
+	Hermetic bool `yaml:"hermetic,omitempty"`
+
+	// SandboxPaths are the host paths that are visible, read-only, to hermetic builds, e.g. the
+	// directories containing go and protoc. They default to the usual system directories
+	SandboxPaths []string `yaml:"sandboxPaths,omitempty"`
+
+	// EnvPassthrough lists the environment variables that are passed from the host to builds, in
+	// addition to the inherited ones such as HOME and PATH
+	EnvPassthrough []string `yaml:"envPassthrough,omitempty"`
+
+	// Env sets environment variables for the builds of every package in the workspace
+	Env map[string]string `yaml:"env,omitempty"`
+}
+
+// configSetting describes how a setting of the workspace config is read and written as a string
+type configSetting struct {
+	get func(c *WorkspaceConfig) string
+	set func(c *WorkspaceConfig, value string) error
+}
+
+var configSettings = map[string]configSetting{
+	"logLevel": {
+		get: func(c *WorkspaceConfig) string {
+			if c.LogLevel == "" {
+				return "info"
+			}
+			return c.LogLevel
+		},
+		set: func(c *WorkspaceConfig, value string) error {
+			c.LogLevel = value
+			return nil
+		},
+	},
+	"offline": {
+		get: func(c *WorkspaceConfig) string { return strconv.FormatBool(c.Offline) },
+		set: func(c *WorkspaceConfig, value string) (err error) {
+			c.Offline = false
+			if value != "" {
+				c.Offline, err = strconv.ParseBool(value)
+			}
+			return err
+		},
+	},
+	"cacheSize": {
+		get: func(c *WorkspaceConfig) string { return c.CacheSize },
+		set: func(c *WorkspaceConfig, value string) error {
+			c.CacheSize = value
+			return nil
+		},
+	},
+	"parallelism": {
+		get: func(c *WorkspaceConfig) string { return strconv.Itoa(c.GetParallelism()) },
+		set: func(c *WorkspaceConfig, value string) (err error) {
+			c.Parallelism = 0
+			if value != "" {
+				c.Parallelism, err = strconv.Atoi(value)
+			}
+			return err
+		},
+	},
+	"defaultResolver": {
+		get: func(c *WorkspaceConfig) string { return c.GetDefaultResolverName() },
+		set: func(c *WorkspaceConfig, value string) error {
+			c.DefaultResolver = value
+			return nil
+		},
+	},
+	"packageRoots": {
+		get: func(c *WorkspaceConfig) string { return strings.Join(c.PackageRoots, ",") },
+		set: func(c *WorkspaceConfig, value string) error {
+			c.PackageRoots = splitList(value)
+			return nil
+		},
+	},
+	"packageMaxDepth": {
+		get: func(c *WorkspaceConfig) string { return strconv.Itoa(c.GetPackageMaxDepth()) },
+		set: func(c *WorkspaceConfig, value string) (err error) {
+			c.PackageMaxDepth = 0
+			if value != "" {
+				c.PackageMaxDepth, err = strconv.Atoi(value)
+			}
+			return err
+		},
+	},
+	"packageIgnore": {
+		get: func(c *WorkspaceConfig) string { return strings.Join(c.PackageIgnore, ",") },
+		set: func(c *WorkspaceConfig, value string) error {
+			c.PackageIgnore = splitList(value)
+			return nil
+		},
+	},
+	"buildCache": {
+		get: func(c *WorkspaceConfig) string { return c.BuildCache },
+		set: func(c *WorkspaceConfig, value string) error {
+			c.BuildCache = value
+			return nil
+		},
+	},
+	"buildCacheSize": {
+		get: func(c *WorkspaceConfig) string {
+			if c.BuildCacheSize == "" {
+				return defaultBuildCacheSize
+			}
+			return c.BuildCacheSize
+		},
+		set: func(c *WorkspaceConfig, value string) error {
+			c.BuildCacheSize = value
+			return nil
+		},
+	},
+	"hermetic": {
+		get: func(c *WorkspaceConfig) string { return strconv.FormatBool(c.Hermetic) },
+		set: func(c *WorkspaceConfig, value string) (err error) {
+			c.Hermetic = false
+			if value != "" {
+				c.Hermetic, err = strconv.ParseBool(value)
+			}
+			return err
+		},
+	},
+	"sandboxPaths": {
+		get: func(c *WorkspaceConfig) string { return strings.Join(c.GetSandboxPaths(), ",") },
+		set: func(c *WorkspaceConfig, value string) error {
+			c.SandboxPaths = splitList(value)
+			return nil
+		},
+	},
+	"envPassthrough": {
+		get: func(c *WorkspaceConfig) string { return strings.Join(c.EnvPassthrough, ",") },
+		set: func(c *WorkspaceConfig, value string) error {
+			c.EnvPassthrough = splitList(value)
+			return nil
+		},
+	},
+}
+
+func splitList(value string) []string {
+	list := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// GetWorkspaceConfig returns the settings of the workspace containing the directory. The default
+// settings are returned if the workspace has no config file
+func GetWorkspaceConfig(directory string) (*WorkspaceConfig, error) {
+	workspace, err := GetWorkspace(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	configFileLocation := filepath.Join(workspace, workspaceDirName, workspaceConfigFileName)
+	configBytes, err := ioutil.ReadFile(configFileLocation)
+	if os.IsNotExist(err) {
+		return &WorkspaceConfig{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Error reading workspace config %s: %+v", configFileLocation, err)
+	}
+
+	workspaceConfig := &WorkspaceConfig{}
+	if err := yaml.Unmarshal(configBytes, workspaceConfig); err != nil {
+		return nil, fmt.Errorf("Error parsing workspace config %s: %+v", configFileLocation, err)
+	}
+
+	if err := workspaceConfig.validate(); err != nil {
+		return nil, fmt.Errorf("Invalid workspace config %s: %+v", configFileLocation, err)
+	}
+
+	return workspaceConfig, nil
+}
+
+// WriteWorkspaceConfig writes the settings of the workspace containing the directory
+func WriteWorkspaceConfig(directory string, workspaceConfig *WorkspaceConfig) error {
+	workspace, err := GetWorkspace(directory)
+	if err != nil {
+		return err
+	}
+
+	if err := workspaceConfig.validate(); err != nil {
+		return err
+	}
+
+	configBytes, err := yaml.Marshal(workspaceConfig)
+	if err != nil {
+		return fmt.Errorf("Error encoding workspace config: %+v", err)
+	}
+
+	configFileLocation := filepath.Join(workspace, workspaceDirName, workspaceConfigFileName)
+	if err := ioutil.WriteFile(configFileLocation, configBytes, 0644); err != nil {
+		return fmt.Errorf("Error writing workspace config %s: %+v", configFileLocation, err)
+	}
+
+	return nil
+}
+
+func (c *WorkspaceConfig) validate() error {
+	if c.Parallelism < 0 {
+		return fmt.Errorf("parallelism must be positive, but is %d", c.Parallelism)
+	}
+
+	if c.PackageMaxDepth < 0 {
+		return fmt.Errorf("packageMaxDepth must be positive, but is %d", c.PackageMaxDepth)
+	}
+
+	for _, pattern := range c.PackageIgnore {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("Invalid packageIgnore pattern %s: %+v", pattern, err)
+		}
+	}
+
+	if c.LogLevel != "" {
+		if _, err := buildlog.ParseLogLevel(c.LogLevel); err != nil {
+			return err
+		}
+	}
+
+	if c.DefaultResolver != "" {
+		if _, err := GetDependencyResolver(c.DefaultResolver); err != nil {
+			return err
+		}
+	}
+
+	if _, err := c.GetCacheSizeBytes(); err != nil {
+		return err
+	}
+
+	if _, err := c.GetBuildCacheSizeBytes(); err != nil {
+		return err
+	}
+
+	if c.BuildCache != "" && c.BuildCache != BuildCacheLocal && c.BuildCache != BuildCacheRemote &&
+		!filepath.IsAbs(c.BuildCache) {
+		return fmt.Errorf("buildCache must be %s, %s or an absolute directory, but is %s", BuildCacheLocal,
+			BuildCacheRemote, c.BuildCache)
+	}
+
+	if err := validateEnvNames(c.Env); err != nil {
+		return fmt.Errorf("Invalid env: %+v", err)
+	}
+
+	for _, path := range c.SandboxPaths {
+		if !filepath.IsAbs(path) {
+			return fmt.Errorf("Sandbox path %s must be absolute", path)
+		}
+	}
+
+	for _, root := range c.PackageRoots {
+		if filepath.IsAbs(root) {
+			return fmt.Errorf("Package root %s must be relative to the workspace", root)
+		}
+	}
+
+	return nil
+}
+
+// GetConfigKeys returns the names of every workspace setting, sorted alphabetically
+func GetConfigKeys() []string {
+	keys := make([]string, 0, len(configSettings))
+	for key := range configSettings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Get returns the value of the setting as a string. Lists are comma separated
+func (c *WorkspaceConfig) Get(key string) (string, error) {
+	setting, ok := configSettings[key]
+	if !ok {
+		return "", fmt.Errorf("Unknown setting %s. Expected one of %v", key, GetConfigKeys())
+	}
+	return setting.get(c), nil
+}
+
+// Set parses the value and assigns it to the setting. Lists are comma separated, and an empty value
+// restores the default
+func (c *WorkspaceConfig) Set(key, value string) error {
+	setting, ok := configSettings[key]
+	if !ok {
+		return fmt.Errorf("Unknown setting %s. Expected one of %v", key, GetConfigKeys())
+	}
+
+	if err := setting.set(c, value); err != nil {
+		return fmt.Errorf("Invalid value %q for %s: %+v", value, key, err)
+	}
+
+	return c.validate()
+}
+
+// GetParallelism returns the number of packages that should be built at once
+func (c *WorkspaceConfig) GetParallelism() int {
+	if c.Parallelism > 0 {
+		return c.Parallelism
+	}
+	return runtime.NumCPU()
+}
+
+// GetPackageMaxDepth returns how many directories below a package root are searched for packages
+func (c *WorkspaceConfig) GetPackageMaxDepth() int {
+	if c.PackageMaxDepth > 0 {
+		return c.PackageMaxDepth
+	}
+	return defaultPackageMaxDepth
+}
+
+// GetSandboxPaths returns the host paths that are visible to hermetic builds
+func (c *WorkspaceConfig) GetSandboxPaths() []string {
+	if len(c.SandboxPaths) > 0 {
+		return c.SandboxPaths
+	}
+	return defaultSandboxPaths
+}
+
+// isIgnoredPackageDir returns true if the directory, given relative to the workspace, matches one of
+// the ignore patterns
+func (c *WorkspaceConfig) isIgnoredPackageDir(relativeDir string) bool {
+	relativeDir = filepath.ToSlash(relativeDir)
+	for _, pattern := range c.PackageIgnore {
+		if matched, _ := filepath.Match(pattern, relativeDir); matched {
+			return true
+		}
+		if matched, _ := filepath.Match(pattern, filepath.Base(relativeDir)); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// GetDefaultResolverName returns the name of the resolver used when none is requested
+func (c *WorkspaceConfig) GetDefaultResolverName() string {
+	if c.DefaultResolver != "" {
+		return c.DefaultResolver
+	}
+	return CompileResolverName
+}
+
+// GetWorkspaceOfConfigFile returns the workspace whose settings are stored in the file at the path.
+// The second return value is false if the file isn't a workspace's .workspace/workspace.yaml
+func GetWorkspaceOfConfigFile(path string) (string, bool) {
+	absolutePath, err := filepath.Abs(path)
+	if err != nil || filepath.Base(absolutePath) != workspaceConfigFileName ||
+		filepath.Base(filepath.Dir(absolutePath)) != workspaceDirName {
+		return "", false
+	}
+
+	workspace := filepath.Dir(filepath.Dir(absolutePath))
+	if _, err := os.Stat(filepath.Join(workspace, workspaceDirName, metadataFileName)); err != nil {
+		return "", false
+	}
+	return workspace, true
+}
+
+// GetCacheSizeBytes returns the maximum size of the package cache in bytes, or 0 if it is unlimited
+func (c *WorkspaceConfig) GetCacheSizeBytes() (int64, error) {
+	if strings.TrimSpace(c.CacheSize) == "" {
+		return 0, nil
+	}
+	return parseSize(c.CacheSize)
+}
+
+// GetBuildCacheSizeBytes returns the maximum size of a local or directory build cache in bytes
+func (c *WorkspaceConfig) GetBuildCacheSizeBytes() (int64, error) {
+	if strings.TrimSpace(c.BuildCacheSize) == "" {
+		return parseSize(defaultBuildCacheSize)
+	}
+	return parseSize(c.BuildCacheSize)
+}
+
+// parseSize parses a size such as 500MB or 10GB into bytes
+func parseSize(raw string) (int64, error) {
+	size := strings.ToUpper(strings.TrimSpace(raw))
+	digits := strings.TrimRight(size, "BKMGT")
+	multiplier, ok := sizeUnits[strings.TrimSpace(size[len(digits):])]
+	number, err := strconv.ParseInt(strings.TrimSpace(digits), 10, 64)
+	if !ok || err != nil || number < 0 {
+		return 0, fmt.Errorf("Invalid cache size %s. Expected a size such as 500MB or 10GB", raw)
+	}
+
+	return number * multiplier, nil
+}
+
+// GetPassthroughEnv returns the NAME=value pairs of the passthrough variables that are set on the host
+func (c *WorkspaceConfig) GetPassthroughEnv() []string {
+	env := make([]string, 0)
+	for _, name := range c.EnvPassthrough {
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+	return env
+}
+
+// GetConfiguredLogLevel returns the log level set in the config of the workspace containing the
+// directory. Info is returned if the directory isn't in a workspace
+func GetConfiguredLogLevel(directory string) (buildlog.LogLevel, error) {
+	workspaceConfig, err := GetWorkspaceConfig(directory)
+	if err == ErrWorkspaceNotFound || (err == nil && workspaceConfig.LogLevel == "") {
+		return buildlog.Info, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return buildlog.ParseLogLevel(workspaceConfig.LogLevel)
+}
+
+// SetOffline forces offline mode for every workspace, regardless of the workspace settings
+func SetOffline(enabled bool) {
+	offline = enabled
+}
+
+// IsOffline returns true if the remote source set and manager of the workspace containing the
+// directory must not be used
+func IsOffline(directory string) (bool, error) {
+	if offline {
+		return true, nil
+	}
+
+	workspaceConfig, err := GetWorkspaceConfig(directory)
+	if err != nil {
+		return false, err
+	}
+
+	return workspaceConfig.Offline, nil
+}
@@ -0,0 +1,81 @@
+package local
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dimes/zbuild/versions"
+)
+
+// conflict is a namespace/name that resolved to more than one version in the dependency closure
+type conflict struct {
+	namespace string
+	name      string
+	versions  []string                         // The conflicting versions, in the order they were found
+	paths     map[string][]*ResolvedDependency // The dependencies that introduced each version
+}
+
+// findConflicts returns the conflicts in the resolved dependencies, in the order they were found
+func findConflicts(resolved []*ResolvedDependency) []*conflict {
+	byName := make(map[string]*conflict)
+	order := make([]string, 0)
+	for _, dependency := range resolved {
+		artifact := dependency.Artifact
+		key := packageNameKey(artifact.Namespace, artifact.Name)
+		existing, ok := byName[key]
+		if !ok {
+			existing = &conflict{
+				namespace: artifact.Namespace,
+				name:      artifact.Name,
+				paths:     make(map[string][]*ResolvedDependency),
+			}
+			byName[key] = existing
+			order = append(order, key)
+		}
+
+		if _, ok := existing.paths[artifact.Version]; !ok {
+			existing.versions = append(existing.versions, artifact.Version)
+		}
+		existing.paths[artifact.Version] = append(existing.paths[artifact.Version], dependency)
+	}
+
+	conflicts := make([]*conflict, 0)
+	for _, key := range order {
+		if len(byName[key].versions) > 1 {
+			conflicts = append(conflicts, byName[key])
+		}
+	}
+
+	return conflicts
+}
+
+func (c *conflict) newest() string {
+	newest := c.versions[0]
+	for _, version := range c.versions[1:] {
+		if versions.Compare(version, newest) > 0 {
+			newest = version
+		}
+	}
+	return newest
+}
+
+// String describes the conflict along with every dependency path that introduced each version
+func (c *conflict) String() string {
+	lines := []string{fmt.Sprintf("Found multiple versions of %s/%s in the dependency closure:",
+		c.namespace, c.name)}
+	for _, version := range c.versions {
+		lines = append(lines, fmt.Sprintf("  %s required by:", version))
+		for _, dependency := range c.paths[version] {
+			lines = append(lines, fmt.Sprintf("    %s", dependency.Path()))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func conflictNames(conflicts []*conflict) string {
+	names := make([]string, len(conflicts))
+	for i, conflict := range conflicts {
+		names[i] = packageNameKey(conflict.namespace, conflict.name)
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,241 @@
+package local
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/dimes/zbuild/buildlog"
+	"github.com/dimes/zbuild/model"
+)
+
+const (
+	// packageDirVar refers to the package's directory in env values, or to the build output of the
+	// exporting dependency in exported values
+	packageDirVar = "PACKAGE_DIR"
+
+	// EnvOriginHost is the origin of variables inherited from the host
+	EnvOriginHost = "host"
+
+	// EnvOriginWorkspace is the origin of variables set in the workspace settings
+	EnvOriginWorkspace = "workspace"
+
+	// EnvOriginPackage is the origin of variables set in the package's build file
+	EnvOriginPackage = "package"
+
+	// EnvOriginTools is the origin of the tool dependencies' directories in PATH
+	EnvOriginTools = "tool dependencies"
+)
+
+var (
+	envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+	// inheritedEnv are the host variables that builds inherit even if they aren't passed through
+	inheritedEnv = []string{
+		"HOME", "USER", "LOGNAME", "PATH", "SHELL", "TMPDIR", "TZ", "LANG", "LC_ALL", "LC_CTYPE",
+		"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY", "http_proxy", "https_proxy", "no_proxy",
+		"SSL_CERT_FILE", "SSL_CERT_DIR", "GOCACHE", "GOROOT", "GOTMPDIR",
+	}
+)
+
+// EnvVar is a variable of a build environment, along with where its value came from
+type EnvVar struct {
+	Name   string
+	Value  string
+	Origin string // e.g. host, workspace, package, or the namespace/name/version of a dependency
+}
+
+// BuildEnv is the environment a package is built with
+type BuildEnv struct {
+	vars map[string]*EnvVar
+}
+
+// GetBuildEnv returns the environment the package is built with. Later sources take precedence:
+//
+//  1. the inherited host variables and the workspace's passthrough variables
+//  2. the variables exported by the compile and tool dependencies. Nearer dependencies take
+//     precedence over the dependencies they depend on
+//  3. the env of the workspace settings
+//  4. the env of the package's build file
+//
+// Finally, the executable directories of the tool dependencies are prepended to PATH. Builders may
+// set variables of their own on top of this
+func GetBuildEnv(workspace string, parsedBuildfile *model.ParsedBuildfile) (*BuildEnv, error) {
+	workspaceConfig, err := GetWorkspaceConfig(workspace)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading workspace config: %+v", err)
+	}
+
+	if err := validateEnvNames(parsedBuildfile.Env); err != nil {
+		return nil, fmt.Errorf("Invalid env in build file of %s: %+v", parsedBuildfile.Package.String(), err)
+	}
+
+	env := &BuildEnv{vars: make(map[string]*EnvVar)}
+	for _, name := range append(inheritedEnv, workspaceConfig.EnvPassthrough...) {
+		if value, ok := os.LookupEnv(name); ok {
+			env.Set(name, value, EnvOriginHost)
+		}
+	}
+
+	exported, err := getExportedEnv(workspace, parsedBuildfile.Package)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, dependency := range exported {
+		if err := validateEnvNames(dependency.Artifact.ExportEnv); err != nil {
+			return nil, fmt.Errorf("Invalid exportEnv of %s: %+v", dependency.Artifact.String(), err)
+		}
+		env.setAll(dependency.Artifact.ExportEnv, dependency.OutputDir(), dependency.Artifact.String())
+	}
+
+	env.setAll(workspaceConfig.Env, parsedBuildfile.AbsoluteWorkingDir, EnvOriginWorkspace)
+	env.setAll(parsedBuildfile.Env, parsedBuildfile.AbsoluteWorkingDir, EnvOriginPackage)
+
+	toolPath, err := GetToolPath(workspace, parsedBuildfile.Package)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting tool path: %+v", err)
+	}
+
+	if len(toolPath) > 0 {
+		env.Prepend("PATH", strings.Join(toolPath, string(os.PathListSeparator)), EnvOriginTools)
+	}
+
+	return env, nil
+}
+
+// getExportedEnv returns the compile and tool dependencies that export variables, in the order their
+// exports are applied, i.e. the farthest dependencies come first
+func getExportedEnv(workspace string, target model.Package) ([]*ResolvedDependency, error) {
+	seen := make(map[string]bool)
+	exported := make([]*ResolvedDependency, 0)
+	for _, resolver := range []DependencyResolver{CompileDependencyResolver, ToolDependencyResolver} {
+		resolved, err := GetResolvedDependencies(workspace, target, resolver)
+		if err != nil {
+			return nil, fmt.Errorf("Error resolving dependencies of %s: %+v", target.String(), err)
+		}
+
+		for _, dependency := range resolved[1:] {
+			key := packageToMapKey(dependency.Artifact.Package)
+			if len(dependency.Artifact.ExportEnv) == 0 || seen[key] {
+				continue
+			}
+			seen[key] = true
+			exported = append(exported, dependency)
+		}
+	}
+
+	sort.SliceStable(exported, func(i, j int) bool {
+		return exported[i].Depth > exported[j].Depth
+	})
+
+	return exported, nil
+}
+
+// setAll sets the variables in the order of their names. Values are expanded against the environment
+// as it is before any of them are set, with ${PACKAGE_DIR} referring to packageDir
+func (e *BuildEnv) setAll(vars map[string]string, packageDir, origin string) {
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	expanded := make(map[string]string)
+	for _, name := range names {
+		expanded[name] = os.Expand(vars[name], func(reference string) string {
+			if reference == packageDirVar {
+				return packageDir
+			}
+			return e.Get(reference)
+		})
+	}
+
+	for _, name := range names {
+		if previous := e.vars[name]; previous != nil && previous.Origin != EnvOriginHost &&
+			previous.Value != expanded[name] {
+			buildlog.Debugf("%s from %s overrides %s from %s", name, origin, name, previous.Origin)
+		}
+		e.Set(name, expanded[name], origin)
+	}
+}
+
+// Get returns the value of the variable, or an empty string if it isn't set
+func (e *BuildEnv) Get(name string) string {
+	if envVar := e.vars[name]; envVar != nil {
+		return envVar.Value
+	}
+	return ""
+}
+
+// Set sets the variable, replacing any previous value
+func (e *BuildEnv) Set(name, value, origin string) {
+	e.vars[name] = &EnvVar{Name: name, Value: value, Origin: origin}
+}
+
+// Prepend adds the value to the front of a list variable such as PATH. The origin is added to the
+// variable's existing origin
+func (e *BuildEnv) Prepend(name, value, origin string) {
+	previous := e.vars[name]
+	if previous == nil || previous.Value == "" {
+		e.Set(name, value, origin)
+		return
+	}
+
+	e.Set(name, value+string(os.PathListSeparator)+previous.Value, origin+", "+previous.Origin)
+}
+
+// Vars returns every variable, sorted by name
+func (e *BuildEnv) Vars() []*EnvVar {
+	vars := make([]*EnvVar, 0, len(e.vars))
+	for _, envVar := range e.vars {
+		vars = append(vars, envVar)
+	}
+
+	sort.Slice(vars, func(i, j int) bool {
+		return vars[i].Name < vars[j].Name
+	})
+	return vars
+}
+
+// Environ returns the variables as NAME=value pairs, suitable for exec.Cmd's Env
+func (e *BuildEnv) Environ() []string {
+	environ := make([]string, 0, len(e.vars))
+	for _, envVar := range e.Vars() {
+		environ = append(environ, envVar.Name+"="+envVar.Value)
+	}
+	return environ
+}
+
+// getDeclaredEnvDigest returns a digest of the workspace's env setting, or an empty string if it has
+// none. The env of build files is covered by their own digests
+func getDeclaredEnvDigest(workspaceConfig *WorkspaceConfig) string {
+	if len(workspaceConfig.Env) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(workspaceConfig.Env))
+	for name := range workspaceConfig.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	declared := &bytes.Buffer{}
+	for _, name := range names {
+		fmt.Fprintf(declared, "%s=%s\n", name, workspaceConfig.Env[name])
+	}
+	return digestBytes(declared.Bytes())
+}
+
+// validateEnvNames returns an error if any of the names isn't a valid variable name
+func validateEnvNames(vars map[string]string) error {
+	for name := range vars {
+		if !envNamePattern.MatchString(name) {
+			return fmt.Errorf("Invalid environment variable name %q", name)
+		}
+	}
+	return nil
+}
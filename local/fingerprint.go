@@ -0,0 +1,400 @@
+package local
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dimes/zbuild/model"
+)
+
+const (
+	fingerprintDirName = "fingerprints"
+)
+
+// Fingerprint records the inputs of a package's build. A package doesn't need to be rebuilt as long
+// as the fingerprint of its last successful build matches the current one
+type Fingerprint struct {
+	Builder   string // The type and version of the builder, e.g. go@1
+	Buildfile string // The digest of the raw build file
+	Env       string `json:",omitempty"` // The digest of the workspace's env setting, if any
+
+	// Sources maps the path of every source file, relative to the package, to its digest. Hidden
+	// files, the build directory and the build file are not sources
+	Sources map[string]string
+
+	// Dependencies maps the namespace/name/version of every dependency in the compile, tool and runtime
+	// closures to the build that was used. For workspace packages, this is the digest of their own
+	// fingerprint
+	Dependencies map[string]string
+}
+
+// ComputeFingerprint returns the fingerprint of the package's current inputs. The builder identifies
+// the type and version of the builder that will build it
+func ComputeFingerprint(workspace string, parsedBuildfile *model.ParsedBuildfile,
+	builder string) (*Fingerprint, error) {
+	workspaceConfig, err := GetWorkspaceConfig(workspace)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading workspace config: %+v", err)
+	}
+
+	fingerprint := &Fingerprint{
+		Builder:      builder,
+		Buildfile:    digestBytes(parsedBuildfile.RawBuildfile),
+		Env:          getDeclaredEnvDigest(workspaceConfig),
+		Sources:      make(map[string]string),
+		Dependencies: make(map[string]string),
+	}
+
+	err = walkSources(parsedBuildfile, func(relativePath, path string, info os.FileInfo) error {
+		if relativePath == model.BuildfileName {
+			return nil
+		}
+
+		digest, err := digestFile(path)
+		if err != nil {
+			return err
+		}
+
+		fingerprint.Sources[relativePath] = digest
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error reading sources of %s: %+v", parsedBuildfile.Package.String(), err)
+	}
+
+	for _, resolver := range buildResolvers {
+		resolved, err := GetResolvedDependencies(workspace, parsedBuildfile.Package, resolver)
+		if err != nil {
+			return nil, fmt.Errorf("Error resolving dependencies of %s: %+v", parsedBuildfile.Package.String(), err)
+		}
+
+		for _, dependency := range resolved[1:] {
+			build := fmt.Sprintf("build %s", dependency.Artifact.BuildNumber)
+			if dependency.Artifact.Digest != "" {
+				build = fmt.Sprintf("%s (%s)", build, dependency.Artifact.Digest)
+			}
+
+			if dependency.Origin == OriginWorkspace {
+				build = "unbuilt"
+				previous, err := readFingerprint(workspace, dependency.Location)
+				if err != nil {
+					return nil, err
+				}
+
+				if previous != nil {
+					if build, err = previous.Digest(); err != nil {
+						return nil, err
+					}
+				}
+			}
+
+			fingerprint.Dependencies[packageToMapKey(dependency.Artifact.Package)] = build
+		}
+	}
+
+	return fingerprint, nil
+}
+
+// GetSourceState returns a cheap summary of the package's source files and build file, based on
+// their names, sizes and modification times. It changes whenever one of them is edited
+func GetSourceState(parsedBuildfile *model.ParsedBuildfile) (string, error) {
+	state := &bytes.Buffer{}
+	err := walkSources(parsedBuildfile, func(relativePath, path string, info os.FileInfo) error {
+		fmt.Fprintf(state, "%s %d %d\n", relativePath, info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("Error reading sources of %s: %+v", parsedBuildfile.Package.String(), err)
+	}
+
+	return state.String(), nil
+}
+
+// walkSources calls fn for every file in the package except hidden files and the build directory.
+// The relative path is slash separated
+func walkSources(parsedBuildfile *model.ParsedBuildfile,
+	fn func(relativePath, path string, info os.FileInfo) error) error {
+	packageDir := parsedBuildfile.AbsoluteWorkingDir
+	return filepath.Walk(packageDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if path == packageDir {
+			return nil
+		}
+
+		if path == parsedBuildfile.AbsoluteBuildDir || strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		relativePath, err := filepath.Rel(packageDir, path)
+		if err != nil {
+			return err
+		}
+
+		return fn(filepath.ToSlash(relativePath), path, info)
+	})
+}
+
+// Digest returns a digest of the whole fingerprint
+func (f *Fingerprint) Digest() (string, error) {
+	// Maps are encoded with sorted keys, so equal fingerprints have equal digests
+	fingerprintBytes, err := json.Marshal(f)
+	if err != nil {
+		return "", fmt.Errorf("Error encoding fingerprint: %+v", err)
+	}
+	return digestBytes(fingerprintBytes), nil
+}
+
+// Changes describes every difference between the previous fingerprint and this one, i.e. why the
+// package needs to be rebuilt. The previous fingerprint may be nil
+func (f *Fingerprint) Changes(previous *Fingerprint) []string {
+	if previous == nil {
+		return []string{"there is no previous successful build, or its output was removed"}
+	}
+
+	changes := make([]string, 0)
+	if previous.Builder != f.Builder {
+		changes = append(changes, fmt.Sprintf("the builder changed from %s to %s", previous.Builder, f.Builder))
+	}
+
+	if previous.Buildfile != f.Buildfile {
+		changes = append(changes, fmt.Sprintf("%s changed", model.BuildfileName))
+	}
+
+	if previous.Env != f.Env {
+		changes = append(changes, "the env of the workspace settings changed")
+	}
+
+	changes = append(changes, diffDigests("source", previous.Sources, f.Sources)...)
+	changes = append(changes, diffDigests("dependency", previous.Dependencies, f.Dependencies)...)
+	return changes
+}
+
+func diffDigests(kind string, previous, current map[string]string) []string {
+	changes := make([]string, 0)
+	for key, digest := range current {
+		if previousDigest, ok := previous[key]; !ok {
+			changes = append(changes, fmt.Sprintf("%s %s was added", kind, key))
+		} else if previousDigest != digest {
+			changes = append(changes, fmt.Sprintf("%s %s changed", kind, key))
+		}
+	}
+
+	for key := range previous {
+		if _, ok := current[key]; !ok {
+			changes = append(changes, fmt.Sprintf("%s %s was removed", kind, key))
+		}
+	}
+
+	sort.Strings(changes)
+	return changes
+}
+
+// GetFingerprint returns the fingerprint of the package's last successful build, or nil if there is
+// none or its build directory has been removed since
+func GetFingerprint(workspace string, parsedBuildfile *model.ParsedBuildfile) (*Fingerprint, error) {
+	if _, err := os.Stat(parsedBuildfile.AbsoluteBuildDir); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	return readFingerprint(workspace, parsedBuildfile.AbsoluteWorkingDir)
+}
+
+// WriteFingerprint records the fingerprint of a successful build of the package
+func WriteFingerprint(workspace string, parsedBuildfile *model.ParsedBuildfile, fingerprint *Fingerprint) error {
+	fingerprintLocation, err := getFingerprintLocation(workspace, parsedBuildfile.AbsoluteWorkingDir)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(fingerprintLocation), 0755); err != nil {
+		return fmt.Errorf("Error creating directory for %s: %+v", fingerprintLocation, err)
+	}
+
+	fingerprintFile, err := os.OpenFile(fingerprintLocation, openFlags, 0644)
+	if err != nil {
+		return fmt.Errorf("Error opening fingerprint %s: %+v", fingerprintLocation, err)
+	}
+	defer fingerprintFile.Close()
+
+	if err := json.NewEncoder(fingerprintFile).Encode(fingerprint); err != nil {
+		return fmt.Errorf("Error writing fingerprint %s: %+v", fingerprintLocation, err)
+	}
+
+	return fingerprintFile.Close()
+}
+
+// RemoveFingerprint forgets the last successful build of the package, so it is rebuilt next time
+func RemoveFingerprint(workspace string, parsedBuildfile *model.ParsedBuildfile) error {
+	fingerprintLocation, err := getFingerprintLocation(workspace, parsedBuildfile.AbsoluteWorkingDir)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(fingerprintLocation); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Error removing fingerprint %s: %+v", fingerprintLocation, err)
+	}
+
+	return nil
+}
+
+// CleanBuild removes the package's build directory along with the fingerprint of its last build
+func CleanBuild(workspace string, parsedBuildfile *model.ParsedBuildfile) error {
+	if err := RemoveFingerprint(workspace, parsedBuildfile); err != nil {
+		return err
+	}
+
+	buildDir := parsedBuildfile.AbsoluteBuildDir
+	if err := os.RemoveAll(buildDir); err != nil {
+		return fmt.Errorf("Error removing build directory %s: %+v", buildDir, err)
+	}
+
+	return nil
+}
+
+// PendingBuild is a build of a package that has started, but hasn't succeeded yet. The output and
+// fingerprint of the last successful build are kept aside until the build either succeeds or fails
+type PendingBuild struct {
+	workspace           string
+	parsedBuildfile     *model.ParsedBuildfile
+	previousBuildDir    string
+	previousFingerprint *Fingerprint
+}
+
+// StartBuild moves the package's build directory aside and removes its fingerprint, so the build
+// starts from an empty build directory and the output only contains what it produced, e.g. no files
+// that were deleted since the last build. Commit or Rollback must be called once the build is done
+func StartBuild(workspace string, parsedBuildfile *model.ParsedBuildfile) (*PendingBuild, error) {
+	previousFingerprint, err := readFingerprint(workspace, parsedBuildfile.AbsoluteWorkingDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// The previous output is kept next to the build directory, so moving it is a rename. Hidden
+	// directories are not sources, so it isn't part of the package's inputs or of hermetic builds
+	buildDir := parsedBuildfile.AbsoluteBuildDir
+	pendingBuild := &PendingBuild{
+		workspace:           workspace,
+		parsedBuildfile:     parsedBuildfile,
+		previousBuildDir:    filepath.Join(filepath.Dir(buildDir), "."+filepath.Base(buildDir)+".previous"),
+		previousFingerprint: previousFingerprint,
+	}
+
+	if err := os.RemoveAll(pendingBuild.previousBuildDir); err != nil {
+		return nil, fmt.Errorf("Error removing build directory %s: %+v", pendingBuild.previousBuildDir, err)
+	}
+
+	if err := os.Rename(buildDir, pendingBuild.previousBuildDir); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("Error moving build directory %s aside: %+v", buildDir, err)
+	}
+
+	if err := RemoveFingerprint(workspace, parsedBuildfile); err != nil {
+		return nil, err
+	}
+
+	return pendingBuild, nil
+}
+
+// Commit records the fingerprint of the successful build and removes the previous output
+func (p *PendingBuild) Commit(fingerprint *Fingerprint) error {
+	if err := WriteFingerprint(p.workspace, p.parsedBuildfile, fingerprint); err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(p.previousBuildDir); err != nil {
+		return fmt.Errorf("Error removing build directory %s: %+v", p.previousBuildDir, err)
+	}
+
+	return nil
+}
+
+// Rollback discards the output of the failed build and restores the output and fingerprint of the
+// last successful build
+func (p *PendingBuild) Rollback() error {
+	buildDir := p.parsedBuildfile.AbsoluteBuildDir
+	if err := os.RemoveAll(buildDir); err != nil {
+		return fmt.Errorf("Error removing build directory %s: %+v", buildDir, err)
+	}
+
+	if err := os.Rename(p.previousBuildDir, buildDir); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("Error restoring build directory %s: %+v", buildDir, err)
+	}
+
+	if p.previousFingerprint == nil {
+		return nil
+	}
+
+	return WriteFingerprint(p.workspace, p.parsedBuildfile, p.previousFingerprint)
+}
+
+func readFingerprint(workspace, packageDir string) (*Fingerprint, error) {
+	fingerprintLocation, err := getFingerprintLocation(workspace, packageDir)
+	if err != nil {
+		return nil, err
+	}
+
+	fingerprintFile, err := os.Open(fingerprintLocation)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Error opening fingerprint %s: %+v", fingerprintLocation, err)
+	}
+	defer fingerprintFile.Close()
+
+	fingerprint := &Fingerprint{}
+	if err := json.NewDecoder(fingerprintFile).Decode(fingerprint); err != nil {
+		return nil, fmt.Errorf("Error decoding fingerprint %s: %+v", fingerprintLocation, err)
+	}
+
+	return fingerprint, nil
+}
+
+// getFingerprintLocation returns where the fingerprint of the package in packageDir is stored. The
+// fingerprints mirror the layout of the workspace
+func getFingerprintLocation(workspace, packageDir string) (string, error) {
+	relativeDir, err := filepath.Rel(workspace, packageDir)
+	if err != nil || strings.HasPrefix(relativeDir, "..") {
+		return "", fmt.Errorf("Package %s is not in the workspace %s", packageDir, workspace)
+	}
+
+	return filepath.Join(workspace, workspaceDirName, fingerprintDirName, relativeDir+".json"), nil
+}
+
+func digestBytes(data []byte) string {
+	digest := sha256.Sum256(data)
+	return hex.EncodeToString(digest[:])
+}
+
+func digestFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
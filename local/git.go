@@ -0,0 +1,230 @@
+package local
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/dimes/zbuild/buildlog"
+	"github.com/dimes/zbuild/model"
+	"github.com/dimes/zbuild/versions"
+)
+
+var (
+	// commitPattern matches full SHA-1 and SHA-256 commit hashes
+	commitPattern = regexp.MustCompile(`^([0-9a-f]{40}|[0-9a-f]{64})$`)
+)
+
+// runGit runs git in the directory and returns its trimmed standard output
+func runGit(dir string, args ...string) (string, error) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("Error running git %s: %+v: %s", strings.Join(args, " "), err,
+			strings.TrimSpace(stderr.String()))
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// GetCommit returns the commit checked out in the git repository containing the directory. The second
+// return value is true if the repository has uncommitted changes
+func GetCommit(dir string) (string, bool, error) {
+	commit, err := runGit(dir, "rev-parse", "HEAD")
+	if err != nil {
+		return "", false, err
+	}
+
+	status, err := runGit(dir, "status", "--porcelain", "--", ".")
+	if err != nil {
+		return "", false, err
+	}
+
+	return commit, status != "", nil
+}
+
+// CheckoutPackage clones the repository of the package's in-use build into the destination, which
+// defaults to a directory named after the package in the workspace. The destination must be inside
+// the workspace. The commit the build came from is checked out if it is known. An empty version
+// selects the highest version in the source set. The package may be anywhere in the repository, and
+// its location is returned
+func CheckoutPackage(workspace, namespace, name, version, destination string) (string, error) {
+	artifact, err := getInUseArtifact(workspace, namespace, name, version)
+	if err != nil {
+		return "", err
+	}
+
+	if artifact.Repository == "" {
+		return "", fmt.Errorf("%s build %s does not declare a repository in its %s", artifact.String(),
+			artifact.BuildNumber, model.BuildfileName)
+	}
+
+	// The commit comes from artifact metadata anyone publishing to the source set can set, so it must
+	// not be mistaken for an option
+	if artifact.Commit != "" && !commitPattern.MatchString(artifact.Commit) {
+		return "", fmt.Errorf("%s build %s records %q as its commit, which is not a commit hash",
+			artifact.String(), artifact.BuildNumber, artifact.Commit)
+	}
+
+	if destination == "" {
+		destination = filepath.Join(workspace, name)
+	}
+
+	destination, err = filepath.Abs(destination)
+	if err != nil {
+		return "", fmt.Errorf("Error determining absolute path for %s: %+v", destination, err)
+	}
+
+	relativeDestination, err := filepath.Rel(workspace, destination)
+	topDir := strings.Split(relativeDestination, string(os.PathSeparator))[0]
+	if err != nil || topDir == "." || topDir == ".." || topDir == workspaceDirName {
+		return "", fmt.Errorf("%s is not a directory inside the workspace %s, so the checkout would not "+
+			"override the source set", destination, workspace)
+	}
+
+	if _, err := os.Stat(destination); err == nil {
+		return "", fmt.Errorf("%s already exists", destination)
+	}
+
+	buildlog.Infof("Cloning %s into %s", artifact.Repository, destination)
+	if _, err := runGit(workspace, "-c", "protocol.ext.allow=never", "clone", "--", artifact.Repository,
+		destination); err != nil {
+		return "", err
+	}
+
+	location, err := setUpCheckout(workspace, artifact, destination)
+	if err != nil {
+		// A partial checkout would override the source set, and block retrying
+		if removeErr := os.RemoveAll(destination); removeErr != nil {
+			buildlog.Warningf("Error removing %s: %+v", destination, removeErr)
+		}
+		return "", err
+	}
+
+	return location, nil
+}
+
+// setUpCheckout checks out the artifact's commit in the cloned destination, and returns the location
+// of the package in it. The package must be discovered as a workspace package
+func setUpCheckout(workspace string, artifact *model.Artifact, destination string) (string, error) {
+	if artifact.Commit != "" {
+		buildlog.Infof("Checking out commit %s, which build %s came from", artifact.Commit,
+			artifact.BuildNumber)
+		if _, err := runGit(destination, "checkout", "--quiet", "--detach", artifact.Commit); err != nil {
+			return "", err
+		}
+	} else {
+		buildlog.Warningf("The commit of %s build %s is unknown, so the default branch is checked out",
+			artifact.String(), artifact.BuildNumber)
+	}
+
+	parsedBuildfile, err := findPackageInCheckout(destination, artifact.Namespace, artifact.Name)
+	if err != nil {
+		return "", fmt.Errorf("Error finding %s/%s in the checkout of %s: %+v", artifact.Namespace,
+			artifact.Name, artifact.Repository, err)
+	}
+
+	if parsedBuildfile.Version != artifact.Version {
+		buildlog.Warningf("The checkout contains version %s of %s/%s, not %s", parsedBuildfile.Version,
+			artifact.Namespace, artifact.Name, artifact.Version)
+	}
+
+	workspacePackages, err := findWorkspacePackages(workspace)
+	if err != nil {
+		return "", err
+	}
+
+	for _, workspacePackage := range workspacePackages {
+		if workspacePackage.AbsoluteWorkingDir == parsedBuildfile.AbsoluteWorkingDir {
+			return parsedBuildfile.AbsoluteWorkingDir, nil
+		}
+	}
+
+	return "", fmt.Errorf("%s was checked out into %s, but it isn't discovered as a workspace package. Add "+
+		"its directory to packageRoots, raise packageMaxDepth or change packageIgnore in the workspace "+
+		"settings", parsedBuildfile.Package.String(), parsedBuildfile.AbsoluteWorkingDir)
+}
+
+// findPackageInCheckout returns the build file of the package anywhere in the checkout. If several
+// directories contain it, the one closest to the root of the checkout is used
+func findPackageInCheckout(checkout, namespace, name string) (*model.ParsedBuildfile, error) {
+	var found *model.ParsedBuildfile
+	foundDepth := 0
+	err := filepath.Walk(checkout, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() && path != checkout && strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir
+		}
+
+		if info.IsDir() || info.Name() != model.BuildfileName {
+			return nil
+		}
+
+		depth := strings.Count(path, string(os.PathSeparator))
+		if found != nil && depth >= foundDepth {
+			return nil
+		}
+
+		parsedBuildfile, err := model.ParseBuildfile(path)
+		if err != nil {
+			buildlog.Debugf("Ignoring %s: %+v", path, err)
+			return nil
+		}
+
+		if parsedBuildfile.Namespace == namespace && parsedBuildfile.Name == name {
+			found, foundDepth = parsedBuildfile, depth
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if found == nil {
+		return nil, fmt.Errorf("No %s declares %s/%s", model.BuildfileName, namespace, name)
+	}
+
+	return found, nil
+}
+
+// getInUseArtifact returns the build of the package that the workspace's source set uses
+func getInUseArtifact(workspace, namespace, name, version string) (*model.Artifact, error) {
+	sourceSet, err := newWorkspaceSourceSet(workspace)
+	if err != nil {
+		return nil, err
+	}
+
+	if version == "" {
+		available := sourceSet.getVersions(namespace, name)
+		if len(available) == 0 {
+			return nil, fmt.Errorf("%s/%s is not in the source set", namespace, name)
+		}
+
+		// Prefer the highest release, but fall back to pre-releases if there are no releases
+		versions.Sort(available)
+		version = available[len(available)-1]
+		if constraint, err := versions.ParseConstraint(model.AnyVersion); err == nil {
+			if highest, ok := constraint.Highest(available); ok {
+				version = highest
+			}
+		}
+	}
+
+	artifact, err := sourceSet.GetArtifact(namespace, name, version)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting %s/%s/%s from the source set: %+v", namespace, name, version, err)
+	}
+
+	return artifact, nil
+}
@@ -0,0 +1,218 @@
+package local
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/dimes/zbuild/model"
+)
+
+// DependencyGraph is the resolved dependency graph of one or more packages. Each artifact appears once,
+// no matter how many paths lead to it
+type DependencyGraph struct {
+	Nodes    []*GraphNode      `json:"nodes"`
+	Edges    []*GraphEdge      `json:"edges"`
+	Excluded []*GraphExclusion `json:"excluded,omitempty"`
+
+	nodeIndex map[string]*GraphNode
+	edgeIndex map[string]bool
+}
+
+// GraphNode is a resolved artifact in the dependency graph
+type GraphNode struct {
+	ID          string `json:"id"`
+	Namespace   string `json:"namespace"`
+	Name        string `json:"name"`
+	Version     string `json:"version"`
+	BuildNumber string `json:"buildNumber,omitempty"`
+	Origin      Origin `json:"origin"`
+	Root        bool   `json:"root,omitempty"` // True if the graph was resolved for this package
+}
+
+// GraphEdge is a dependency from one node to another
+type GraphEdge struct {
+	From      string      `json:"from"`
+	To        string      `json:"to"`
+	Scope     model.Scope `json:"scope"`
+	Requested string      `json:"requested"` // The declared version, which may be a constraint
+}
+
+// GraphExclusion is a dependency of a node that was excluded from the graph
+type GraphExclusion struct {
+	From       string      `json:"from"`
+	Namespace  string      `json:"namespace"`
+	Name       string      `json:"name"`
+	Scope      model.Scope `json:"scope"`
+	Requested  string      `json:"requested"`
+	ExcludedBy string      `json:"excludedBy"` // The dependency path that declared the exclusion
+}
+
+// NewDependencyGraph returns an empty dependency graph
+func NewDependencyGraph() *DependencyGraph {
+	return &DependencyGraph{
+		Nodes:     make([]*GraphNode, 0),
+		Edges:     make([]*GraphEdge, 0),
+		Excluded:  make([]*GraphExclusion, 0),
+		nodeIndex: make(map[string]*GraphNode),
+		edgeIndex: make(map[string]bool),
+	}
+}
+
+// Add adds the resolved dependencies of a package to the graph. The first resolved dependency is
+// marked as a root
+func (g *DependencyGraph) Add(resolved []*ResolvedDependency) {
+	for _, dependency := range resolved {
+		node := g.addNode(dependency)
+		if dependency.Parent == nil {
+			node.Root = true
+		} else {
+			g.addEdge(&GraphEdge{
+				From:      packageToMapKey(dependency.Parent.Artifact.Package),
+				To:        node.ID,
+				Scope:     dependency.Scope,
+				Requested: dependency.Requested.Version,
+			})
+		}
+
+		for _, excluded := range dependency.Excluded {
+			exclusion := &GraphExclusion{
+				From:       node.ID,
+				Namespace:  excluded.Requested.Namespace,
+				Name:       excluded.Requested.Name,
+				Scope:      excluded.Scope,
+				Requested:  excluded.Requested.Version,
+				ExcludedBy: excluded.ExcludedBy.Path(),
+			}
+
+			key := fmt.Sprintf("%s|%s/%s|%s|%s", exclusion.From, exclusion.Namespace, exclusion.Name,
+				exclusion.Scope, exclusion.Requested)
+			if !g.edgeIndex[key] {
+				g.edgeIndex[key] = true
+				g.Excluded = append(g.Excluded, exclusion)
+			}
+		}
+	}
+}
+
+func (g *DependencyGraph) addNode(dependency *ResolvedDependency) *GraphNode {
+	artifact := dependency.Artifact
+	id := packageToMapKey(artifact.Package)
+	if node, ok := g.nodeIndex[id]; ok {
+		return node
+	}
+
+	node := &GraphNode{
+		ID:          id,
+		Namespace:   artifact.Namespace,
+		Name:        artifact.Name,
+		Version:     artifact.Version,
+		BuildNumber: artifact.BuildNumber,
+		Origin:      dependency.Origin,
+	}
+	g.nodeIndex[id] = node
+	g.Nodes = append(g.Nodes, node)
+	return node
+}
+
+func (g *DependencyGraph) addEdge(edge *GraphEdge) {
+	key := fmt.Sprintf("%s|%s|%s|%s", edge.From, edge.To, edge.Scope, edge.Requested)
+	if g.edgeIndex[key] {
+		return
+	}
+
+	g.edgeIndex[key] = true
+	g.Edges = append(g.Edges, edge)
+}
+
+// sort orders the nodes and edges by their identifiers, so the output of the same graph is always
+// identical and can be diffed
+func (g *DependencyGraph) sort() {
+	sort.Slice(g.Nodes, func(i, j int) bool {
+		return g.Nodes[i].ID < g.Nodes[j].ID
+	})
+
+	sort.Slice(g.Edges, func(i, j int) bool {
+		a, b := g.Edges[i], g.Edges[j]
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		if a.To != b.To {
+			return a.To < b.To
+		}
+		if a.Scope != b.Scope {
+			return a.Scope < b.Scope
+		}
+		return a.Requested < b.Requested
+	})
+
+	sort.Slice(g.Excluded, func(i, j int) bool {
+		a, b := g.Excluded[i], g.Excluded[j]
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		return fmt.Sprintf("%s/%s", a.Namespace, a.Name) < fmt.Sprintf("%s/%s", b.Namespace, b.Name)
+	})
+}
+
+// WriteJSON writes the graph as JSON
+func (g *DependencyGraph) WriteJSON(writer io.Writer) error {
+	g.sort()
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(g)
+}
+
+// WriteDOT writes the graph in the Graphviz DOT format. Workspace packages are drawn as filled boxes,
+// locally published packages as dashed boxes, and excluded dependencies as dotted edges
+func (g *DependencyGraph) WriteDOT(writer io.Writer) error {
+	g.sort()
+	buffer := &bytes.Buffer{}
+	fmt.Fprintf(buffer, "digraph dependencies {\n")
+	fmt.Fprintf(buffer, "  node [shape=box];\n")
+	for _, node := range g.Nodes {
+		label := fmt.Sprintf("%s/%s\\n%s", node.Namespace, node.Name, node.Version)
+		if node.BuildNumber != "" {
+			label = fmt.Sprintf("%s build %s", label, node.BuildNumber)
+		}
+		label = fmt.Sprintf("%s\\n(%s)", label, node.Origin)
+
+		attributes := fmt.Sprintf("label=%s", dotQuote(label))
+		switch node.Origin {
+		case OriginWorkspace:
+			attributes += ", style=filled, fillcolor=lightblue"
+		case OriginLocalRepository:
+			attributes += ", style=dashed"
+		}
+		if node.Root {
+			attributes += ", penwidth=2"
+		}
+
+		fmt.Fprintf(buffer, "  %s [%s];\n", dotQuote(node.ID), attributes)
+	}
+
+	for _, edge := range g.Edges {
+		fmt.Fprintf(buffer, "  %s -> %s [label=%s];\n", dotQuote(edge.From), dotQuote(edge.To),
+			dotQuote(fmt.Sprintf("%s %s", edge.Scope, edge.Requested)))
+	}
+
+	for _, excluded := range g.Excluded {
+		id := fmt.Sprintf("excluded: %s/%s", excluded.Namespace, excluded.Name)
+		fmt.Fprintf(buffer, "  %s [label=%s, style=dotted];\n", dotQuote(id),
+			dotQuote(fmt.Sprintf("%s/%s\\n(excluded)", excluded.Namespace, excluded.Name)))
+		fmt.Fprintf(buffer, "  %s -> %s [label=%s, style=dotted];\n", dotQuote(excluded.From), dotQuote(id),
+			dotQuote(fmt.Sprintf("%s %s", excluded.Scope, excluded.Requested)))
+	}
+
+	fmt.Fprintf(buffer, "}\n")
+	_, err := buffer.WriteTo(writer)
+	return err
+}
+
+// dotQuote quotes a DOT identifier. Unlike %q, it leaves \n escapes alone so they render as line breaks
+func dotQuote(value string) string {
+	return `"` + strings.Replace(value, `"`, `\"`, -1) + `"`
+}
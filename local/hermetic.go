@@ -0,0 +1,173 @@
+package local
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dimes/zbuild/copyutil"
+	"github.com/dimes/zbuild/model"
+	"github.com/dimes/zbuild/sandbox"
+)
+
+const (
+	sandboxRootDirName    = "root"
+	sandboxPackageDirName = "package"
+	sandboxTempDir        = "/tmp"
+)
+
+var (
+	defaultSandboxPaths = []string{"/bin", "/lib", "/lib32", "/lib64", "/sbin", "/usr"}
+)
+
+// HermeticBuild is a build of a package in a sandbox. Besides the system paths, the sandbox only
+// contains a fresh copy of the package's sources, read-only copies of its resolved dependencies and the
+// workspace metadata needed to resolve them again. Undeclared inputs don't exist in the sandbox, so
+// builds that read them fail
+type HermeticBuild struct {
+	Spec *sandbox.Spec
+
+	parsedBuildfile *model.ParsedBuildfile
+	tempDir         string
+}
+
+// NewHermeticBuild prepares a sandbox for building the package. The package's sources appear at their
+// usual location inside the sandbox, so builders don't need to know they are sandboxed. Close must be
+// called once the build is done
+func NewHermeticBuild(workspace string, parsedBuildfile *model.ParsedBuildfile) (*HermeticBuild, error) {
+	workspaceConfig, err := GetWorkspaceConfig(workspace)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading workspace config: %+v", err)
+	}
+
+	tempDir, err := ioutil.TempDir("", "zbuild-sandbox")
+	if err != nil {
+		return nil, fmt.Errorf("Error creating sandbox directory: %+v", err)
+	}
+
+	hermeticBuild := &HermeticBuild{parsedBuildfile: parsedBuildfile, tempDir: tempDir}
+	if err := hermeticBuild.prepare(workspace, workspaceConfig); err != nil {
+		hermeticBuild.Close()
+		return nil, err
+	}
+
+	return hermeticBuild, nil
+}
+
+func (h *HermeticBuild) prepare(workspace string, workspaceConfig *WorkspaceConfig) error {
+	root := filepath.Join(h.tempDir, sandboxRootDirName)
+	if err := os.Mkdir(root, 0755); err != nil {
+		return fmt.Errorf("Error creating sandbox root %s: %+v", root, err)
+	}
+
+	packageDir := filepath.Join(h.tempDir, sandboxPackageDirName)
+	err := walkSources(h.parsedBuildfile, func(relativePath, path string, info os.FileInfo) error {
+		destination := filepath.Join(packageDir, filepath.FromSlash(relativePath))
+		if err := os.MkdirAll(filepath.Dir(destination), 0755); err != nil {
+			return err
+		}
+		return copyutil.Copy(path, destination)
+	})
+	if err != nil {
+		return fmt.Errorf("Error copying sources of %s to the sandbox: %+v", h.parsedBuildfile.Package.String(), err)
+	}
+
+	userDir, err := GetUserDir()
+	if err != nil {
+		return err
+	}
+
+	h.Spec = &sandbox.Spec{
+		Root: root,
+		Dir:  h.parsedBuildfile.AbsoluteWorkingDir,
+		// The sandbox's own variables come last, so they take precedence over passthrough variables
+		Env: append(workspaceConfig.GetPassthroughEnv(),
+			"HOME="+sandboxTempDir,
+			"TMPDIR="+sandboxTempDir,
+			"PATH="+os.Getenv("PATH"),
+			UserDirEnv+"="+userDir),
+		Mounts: []sandbox.Mount{{Source: packageDir, Target: h.parsedBuildfile.AbsoluteWorkingDir, Writable: true}},
+	}
+
+	for _, path := range workspaceConfig.GetSandboxPaths() {
+		if _, err := os.Stat(path); err == nil {
+			h.Spec.Mounts = append(h.Spec.Mounts, sandbox.Mount{Source: path, Target: path})
+		}
+	}
+
+	// The workspace metadata is needed to resolve dependencies. The package cache and fingerprints
+	// aren't, and only the resolved artifacts are mounted from the package cache
+	metadataDir := filepath.Join(workspace, workspaceDirName)
+	metadataFiles, err := ioutil.ReadDir(metadataDir)
+	if err != nil {
+		return fmt.Errorf("Error listing %s: %+v", metadataDir, err)
+	}
+
+	for _, metadataFile := range metadataFiles {
+		if name := metadataFile.Name(); name != workspacePackageCacheDirName && name != fingerprintDirName {
+			h.addMount(filepath.Join(metadataDir, name))
+		}
+	}
+
+	repositoryDir, err := getRepositoryDir()
+	if err != nil {
+		return err
+	}
+
+	repositoryIndex := filepath.Join(repositoryDir, repositoryIndexFileName)
+	if _, err := os.Stat(repositoryIndex); err == nil {
+		h.addMount(repositoryIndex)
+	}
+
+	for _, resolver := range buildResolvers {
+		resolved, err := GetResolvedDependencies(workspace, h.parsedBuildfile.Package, resolver)
+		if err != nil {
+			return fmt.Errorf("Error resolving dependencies of %s: %+v", h.parsedBuildfile.Package.String(), err)
+		}
+
+		for _, dependency := range resolved[1:] {
+			h.addMount(dependency.Location)
+		}
+	}
+
+	return nil
+}
+
+// addMount mounts the host path read-only at the same location in the sandbox, unless it already is
+func (h *HermeticBuild) addMount(path string) {
+	for _, mount := range h.Spec.Mounts {
+		if mount.Target == path {
+			return
+		}
+	}
+	h.Spec.Mounts = append(h.Spec.Mounts, sandbox.Mount{Source: path, Target: path})
+}
+
+// CopyOutput copies the build directory produced in the sandbox to the package's build directory
+func (h *HermeticBuild) CopyOutput() error {
+	relativeBuildDir, err := filepath.Rel(h.parsedBuildfile.AbsoluteWorkingDir, h.parsedBuildfile.AbsoluteBuildDir)
+	if err != nil || strings.HasPrefix(relativeBuildDir, "..") {
+		return fmt.Errorf("Build directory %s is outside of the package", h.parsedBuildfile.AbsoluteBuildDir)
+	}
+
+	buildDir := filepath.Join(h.tempDir, sandboxPackageDirName, relativeBuildDir)
+	if _, err := os.Stat(buildDir); os.IsNotExist(err) {
+		return nil
+	}
+
+	if err := copyutil.Copy(buildDir, h.parsedBuildfile.AbsoluteBuildDir); err != nil {
+		return fmt.Errorf("Error copying build output from the sandbox: %+v", err)
+	}
+
+	return nil
+}
+
+// Close removes the sandbox
+func (h *HermeticBuild) Close() error {
+	if err := os.RemoveAll(h.tempDir); err != nil {
+		return fmt.Errorf("Error removing sandbox %s: %+v", h.tempDir, err)
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package local
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/dimes/zbuild/buildlog"
+	"github.com/dimes/zbuild/model"
+)
+
+var (
+	// lockedResolvers are the resolvers whose closures are recorded in lock files
+	lockedResolvers = []DependencyResolver{CompileDependencyResolver, TestDependencyResolver}
+)
+
+// Lockfile records the exact builds of the artifacts in a package's compile and test closures. When
+// a package has a lock file, the recorded builds are used instead of the ones in the source set
+type Lockfile struct {
+	Artifacts []*model.Artifact
+
+	index map[string]*model.Artifact
+}
+
+func newLockfile(artifacts []*model.Artifact) *Lockfile {
+	sort.Slice(artifacts, func(i, j int) bool {
+		return packageToMapKey(artifacts[i].Package) < packageToMapKey(artifacts[j].Package)
+	})
+
+	index := make(map[string]*model.Artifact)
+	for _, artifact := range artifacts {
+		index[packageNameKey(artifact.Namespace, artifact.Name)] = artifact
+	}
+
+	return &Lockfile{
+		Artifacts: artifacts,
+		index:     index,
+	}
+}
+
+// ReadLockfile reads the lock file in the package directory. A nil lock file is returned if the
+// package has none
+func ReadLockfile(packageDir string) (*Lockfile, error) {
+	lockfileLocation := filepath.Join(packageDir, model.LockfileName)
+	lockfileFile, err := os.Open(lockfileLocation)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Error opening lock file %s: %+v", lockfileLocation, err)
+	}
+	defer lockfileFile.Close()
+
+	lockfile := &Lockfile{}
+	if err := json.NewDecoder(lockfileFile).Decode(lockfile); err != nil {
+		return nil, fmt.Errorf("Error decoding lock file %s: %+v", lockfileLocation, err)
+	}
+
+	return newLockfile(lockfile.Artifacts), nil
+}
+
+// Write writes the lock file into the package directory
+func (l *Lockfile) Write(packageDir string) error {
+	lockfileLocation := filepath.Join(packageDir, model.LockfileName)
+	lockfileFile, err := os.OpenFile(lockfileLocation, openFlags, 0644)
+	if err != nil {
+		return fmt.Errorf("Error opening lock file %s: %+v", lockfileLocation, err)
+	}
+	defer lockfileFile.Close()
+
+	encoder := json.NewEncoder(lockfileFile)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(l); err != nil {
+		return fmt.Errorf("Error writing lock file %s: %+v", lockfileLocation, err)
+	}
+
+	return lockfileFile.Close()
+}
+
+// getArtifact returns the locked artifact for the namespace/name, or nil if there is none. It is
+// safe to call on a nil lock file
+func (l *Lockfile) getArtifact(namespace, name string) *model.Artifact {
+	if l == nil {
+		return nil
+	}
+	return l.index[packageNameKey(namespace, name)]
+}
+
+// useLockfile honors the lock file of the target if it is checked out in the workspace and has one
+func (b *buildpathGenerator) useLockfile(target model.Package) error {
+	packageDir, err := b.overrideSourceSet.getLocationForArtifact(target.Namespace, target.Name,
+		target.Version)
+	if err != nil {
+		buildlog.Debugf("Not using a lock file for %s: %+v", target.String(), err)
+		return nil
+	}
+
+	lock, err := ReadLockfile(packageDir)
+	if err != nil {
+		return err
+	}
+
+	if lock != nil {
+		buildlog.Debugf("Using %s in %s", model.LockfileName, packageDir)
+	}
+
+	b.lock = lock
+	return nil
+}
+
+// LockDependencies resolves the compile and test closures of the target against the workspace's
+// source set and returns a lock file recording them. Packages checked out in the workspace or
+// published to the local repository are never recorded. Unless update is set, builds already
+// recorded in the target's lock file are kept as long as they are still in the closure
+func LockDependencies(workspace string, target model.Package, update bool) (*Lockfile, error) {
+	resolved, err := resolveLockedClosures(workspace, target, !update)
+	if err != nil {
+		return nil, err
+	}
+
+	artifacts := make([]*model.Artifact, 0, len(resolved))
+	lockedVersions := make(map[string]string)
+	for _, key := range sortedArtifactKeys(resolved) {
+		artifact := resolved[key]
+		nameKey := packageNameKey(artifact.Namespace, artifact.Name)
+		if version, ok := lockedVersions[nameKey]; ok {
+			return nil, fmt.Errorf("The compile and test closures use different versions of %s: %s and %s. "+
+				"Add a pin to the resolution section of %s", nameKey, version, artifact.Version,
+				model.BuildfileName)
+		}
+
+		lockedVersions[nameKey] = artifact.Version
+		artifacts = append(artifacts, artifact)
+	}
+
+	return newLockfile(artifacts), nil
+}
+
+// VerifyLockfile compares the target's lock file with the builds currently in the workspace's source
+// set. Each difference is described by one of the returned strings, so an up to date lock file
+// produces none
+func VerifyLockfile(workspace string, target model.Package) ([]string, error) {
+	buildpathGenerator, err := newBuildpathGenerator(workspace)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting buildpath generator for %s: %+v", workspace, err)
+	}
+
+	if err := buildpathGenerator.useLockfile(target); err != nil {
+		return nil, err
+	}
+
+	lock := buildpathGenerator.lock
+	if lock == nil {
+		return nil, fmt.Errorf("%s has no %s. Run zbuild lock to create one", target.String(),
+			model.LockfileName)
+	}
+
+	current, err := resolveLockedClosures(workspace, target, false)
+	if err != nil {
+		return nil, err
+	}
+
+	differences := make([]string, 0)
+	for _, key := range sortedArtifactKeys(current) {
+		artifact := current[key]
+		locked := lock.getArtifact(artifact.Namespace, artifact.Name)
+		switch {
+		case locked == nil:
+			differences = append(differences, fmt.Sprintf("%s build %s is not locked", key,
+				artifact.BuildNumber))
+		case locked.Version != artifact.Version:
+			differences = append(differences, fmt.Sprintf("%s/%s is locked to version %s, but resolves to %s",
+				artifact.Namespace, artifact.Name, locked.Version, artifact.Version))
+		case locked.BuildNumber != artifact.BuildNumber:
+			differences = append(differences, fmt.Sprintf("%s is locked to build %s, but the source set "+
+				"uses build %s", key, locked.BuildNumber, artifact.BuildNumber))
+		case locked.Digest != artifact.Digest:
+			differences = append(differences, fmt.Sprintf("%s build %s is locked with digest %s, but the "+
+				"source set has digest %s", key, artifact.BuildNumber, locked.Digest, artifact.Digest))
+		}
+	}
+
+	for _, locked := range lock.Artifacts {
+		if !containsName(current, locked.Namespace, locked.Name) {
+			differences = append(differences, fmt.Sprintf("%s build %s is locked, but is no longer a "+
+				"dependency", packageToMapKey(locked.Package), locked.BuildNumber))
+		}
+	}
+
+	return differences, nil
+}
+
+// resolveLockedClosures resolves every closure recorded in lock files against the source set and
+// returns the artifacts, keyed by namespace/name/version. The root package is not included
+func resolveLockedClosures(workspace string, target model.Package,
+	useLockfile bool) (map[string]*model.Artifact, error) {
+	buildpathGenerator, err := newBuildpathGenerator(workspace)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting buildpath generator for %s: %+v", workspace, err)
+	}
+	buildpathGenerator.sourceSetOnly = true
+
+	if useLockfile {
+		if err := buildpathGenerator.useLockfile(target); err != nil {
+			return nil, err
+		}
+	}
+
+	artifacts := make(map[string]*model.Artifact)
+	for _, resolver := range lockedResolvers {
+		resolved, err := buildpathGenerator.resolve(target, resolver, false)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, dependency := range resolved[1:] {
+			artifacts[packageToMapKey(dependency.Artifact.Package)] = dependency.Artifact
+		}
+	}
+
+	return artifacts, nil
+}
+
+func sortedArtifactKeys(artifacts map[string]*model.Artifact) []string {
+	keys := make([]string, 0, len(artifacts))
+	for key := range artifacts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func containsName(artifacts map[string]*model.Artifact, namespace, name string) bool {
+	for _, artifact := range artifacts {
+		if artifact.Namespace == namespace && artifact.Name == name {
+			return true
+		}
+	}
+	return false
+}
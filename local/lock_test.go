@@ -0,0 +1,184 @@
+package local
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/dimes/zbuild/model"
+)
+
+const lockTestBuildfile = `namespace: ns
+name: app
+version: "1.0"
+type: go
+dependencies:
+  compile: ["a@1.0"]
+  test: ["t@1.0"]
+`
+
+// withBuild returns a copy of the artifact with the build number and digest
+func withBuild(artifact *model.Artifact, buildNumber, digest string) *model.Artifact {
+	copied := *artifact
+	copied.BuildNumber = buildNumber
+	copied.Digest = digest
+	return &copied
+}
+
+func lockedKeys(lockfile *Lockfile) []string {
+	keys := make([]string, len(lockfile.Artifacts))
+	for i, artifact := range lockfile.Artifacts {
+		keys[i] = packageToMapKey(artifact.Package) + "#" + artifact.BuildNumber
+	}
+	return keys
+}
+
+func TestVerifyLockfile(t *testing.T) {
+	a := testArtifact(t, "ns/a@1.0", "lib@^1")
+	lib := testArtifact(t, "ns/lib@1.0")
+	lib11 := testArtifact(t, "ns/lib@1.1")
+	testDependency := testArtifact(t, "ns/t@1.0")
+	locked := []*model.Artifact{a, lib, testDependency}
+
+	tests := []struct {
+		name        string
+		sourceSet   []*model.Artifact
+		differences []string
+	}{
+		{
+			name:      "an unchanged source set",
+			sourceSet: locked,
+		},
+		{
+			name:      "a new build",
+			sourceSet: []*model.Artifact{a, withBuild(lib, "2", ""), testDependency},
+			differences: []string{
+				"ns/lib/1.0 is locked to build 1, but the source set uses build 2",
+			},
+		},
+		{
+			name:      "a new digest",
+			sourceSet: []*model.Artifact{a, withBuild(lib, "1", "sha256:1234"), testDependency},
+			differences: []string{
+				"ns/lib/1.0 build 1 is locked with digest , but the source set has digest sha256:1234",
+			},
+		},
+		{
+			name:      "a new version matching a constraint",
+			sourceSet: []*model.Artifact{a, lib, lib11, testDependency},
+			differences: []string{
+				"ns/lib is locked to version 1.0, but resolves to 1.1",
+			},
+		},
+		{
+			name: "a dependency that was added and one that was removed",
+			sourceSet: []*model.Artifact{
+				testArtifact(t, "ns/a@1.0", "other@1.0"),
+				testArtifact(t, "ns/other@1.0"),
+				testDependency,
+			},
+			differences: []string{
+				"ns/other/1.0 build 1 is not locked",
+				"ns/lib/1.0 build 1 is locked, but is no longer a dependency",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		workspace := newTestWorkspace(t, locked...)
+		target := workspace.addPackage(t, "app", lockTestBuildfile)
+
+		lockfile, err := LockDependencies(workspace.dir, target, false)
+		if err != nil {
+			t.Fatalf("%s: error locking: %+v", test.name, err)
+		}
+
+		if err := lockfile.Write(workspace.dir + "/app"); err != nil {
+			t.Fatalf("%s: error writing lock file: %+v", test.name, err)
+		}
+
+		workspace.setSourceSet(t, test.sourceSet...)
+		differences, err := VerifyLockfile(workspace.dir, target)
+		workspace.close()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %+v", test.name, err)
+			continue
+		}
+
+		if len(differences) != 0 || len(test.differences) != 0 {
+			if !reflect.DeepEqual(differences, test.differences) {
+				t.Errorf("%s: differences %q, expected %q", test.name, differences, test.differences)
+			}
+		}
+	}
+}
+
+func TestLockDependencies(t *testing.T) {
+	a := testArtifact(t, "ns/a@1.0", "lib@^1")
+	lib := testArtifact(t, "ns/lib@1.0")
+	testDependency := testArtifact(t, "ns/t@1.0")
+
+	workspace := newTestWorkspace(t, a, lib, testDependency)
+	defer workspace.close()
+	target := workspace.addPackage(t, "app", lockTestBuildfile)
+
+	if _, err := VerifyLockfile(workspace.dir, target); err == nil {
+		t.Errorf("Verifying a package without a lock file didn't fail")
+	}
+
+	lockfile, err := LockDependencies(workspace.dir, target, false)
+	if err != nil {
+		t.Fatalf("Error locking: %+v", err)
+	}
+
+	expected := []string{"ns/a/1.0#1", "ns/lib/1.0#1", "ns/t/1.0#1"}
+	if actual := lockedKeys(lockfile); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Locked %v, expected %v", actual, expected)
+	}
+
+	if err := lockfile.Write(workspace.dir + "/app"); err != nil {
+		t.Fatalf("Error writing lock file: %+v", err)
+	}
+
+	read, err := ReadLockfile(workspace.dir + "/app")
+	if err != nil || !reflect.DeepEqual(lockedKeys(read), expected) {
+		t.Fatalf("Read lock file with %v (%+v), expected %v", lockedKeys(read), err, expected)
+	}
+
+	// The source set moves on to a new build of lib 1.0 and a new version that matches a's constraint
+	workspace.setSourceSet(t, a, withBuild(lib, "2", ""), testArtifact(t, "ns/lib@1.1"), testDependency)
+
+	resolved, err := GetResolvedDependencies(workspace.dir, target, CompileDependencyResolver)
+	if err != nil {
+		t.Fatalf("Error resolving: %+v", err)
+	}
+
+	for _, dependency := range resolved {
+		if dependency.Artifact.Name != "lib" {
+			continue
+		}
+
+		if dependency.Artifact.Version != "1.0" || dependency.Artifact.BuildNumber != "1" {
+			t.Errorf("Resolved %s build %s, expected the locked build 1 of ns/lib 1.0",
+				dependency.Artifact.String(), dependency.Artifact.BuildNumber)
+		}
+	}
+
+	kept, err := LockDependencies(workspace.dir, target, false)
+	if err != nil {
+		t.Fatalf("Error locking: %+v", err)
+	}
+
+	if actual := lockedKeys(kept); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Locking without -update locked %v, expected %v", actual, expected)
+	}
+
+	updated, err := LockDependencies(workspace.dir, target, true)
+	if err != nil {
+		t.Fatalf("Error updating lock: %+v", err)
+	}
+
+	expected = []string{"ns/a/1.0#1", "ns/lib/1.1#1", "ns/t/1.0#1"}
+	if actual := lockedKeys(updated); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Locking with -update locked %v, expected %v", actual, expected)
+	}
+}
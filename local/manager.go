@@ -1,8 +1,6 @@
 package local
 
 import (
-	"archive/tar"
-	"compress/gzip"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -62,44 +60,28 @@ func (l *localManager) Setup() error {
 // OpenReader opens a reader to the given artifact. Because the artifact is local, the "build number"
 // is ignored
 func (l *localManager) OpenReader(artifact *model.Artifact) (io.ReadCloser, error) {
-	// The artifact needs to be found in the metadata directory
-	dirs, err := ioutil.ReadDir(l.workspace)
+	// The artifact needs to be found among the workspace packages
+	workspacePackages, err := findWorkspacePackages(l.workspace)
 	if err != nil {
-		return nil, fmt.Errorf("Error listing files in workspace %s: %+v", l.workspace, err)
+		return nil, fmt.Errorf("Error listing packages in workspace %s: %+v", l.workspace, err)
 	}
 
 	packageDir := ""
-	for _, dir := range dirs {
-		if dir.Name() == workspaceDirName {
-			buildlog.Debugf("Ignoring %s because it contains workspace metadata", workspaceDirName)
-			continue
-		}
-
-		if !dir.IsDir() {
-			buildlog.Debugf("Ignoring %s because it is not a directory", dir.Name())
-			continue
-		}
-
-		buildfileLocation := filepath.Join(l.workspace, dir.Name(), model.BuildfileName)
-		parsedBuildfile, err := model.ParseBuildfile(buildfileLocation)
-		if err != nil {
-			buildlog.Debugf("Ignoring %s due to error opening build file: %+v", dir.Name(), err)
-			continue
-		}
-
+	for _, parsedBuildfile := range workspacePackages {
 		if parsedBuildfile.Namespace != artifact.Namespace ||
 			parsedBuildfile.Name != artifact.Name ||
 			parsedBuildfile.Version != artifact.Version {
-			buildlog.Debugf("Ignoring %s due to namespace/name/version mismatch", dir.Name())
+			buildlog.Debugf("Ignoring %s due to namespace/name/version mismatch",
+				parsedBuildfile.AbsoluteWorkingDir)
 			continue
 		}
 
 		if packageDir != "" {
 			return nil, fmt.Errorf("Found duplicate workspace packages for %+v. %s and %s", artifact,
-				packageDir, filepath.Join(l.workspace, dir.Name()))
+				packageDir, parsedBuildfile.AbsoluteWorkingDir)
 		}
 
-		packageDir = filepath.Join(l.workspace, dir.Name())
+		packageDir = parsedBuildfile.AbsoluteWorkingDir
 	}
 
 	if packageDir == "" {
@@ -111,126 +93,82 @@ func (l *localManager) OpenReader(artifact *model.Artifact) (io.ReadCloser, erro
 
 	reader, writer := io.Pipe()
 	go func() {
-		gzipWriter := gzip.NewWriter(writer)
-		tarWriter := tar.NewWriter(gzipWriter)
-		defer writer.Close()
-		defer gzipWriter.Close()
-		defer tarWriter.Close()
-
-		err := filepath.Walk(packageDir, func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-
-			if path == packageDir {
-				return nil
-			}
-
-			header, err := tar.FileInfoHeader(info, info.Name())
-			if err != nil {
-				return fmt.Errorf("Error creating file info header for %s: %+v", path, err)
-			}
-
-			name, err := filepath.Rel(packageDir, path)
-			if err != nil {
-				return fmt.Errorf("Error getting %s relative to %s: %+v", path, packageDir, err)
-			}
-			header.Name = name
-
-			if err := tarWriter.WriteHeader(header); err != nil {
-				return fmt.Errorf("Error writing header for %s: %+v", path, err)
-			}
-
-			if info.IsDir() {
-				return nil
-			}
-
-			file, err := os.Open(path)
-			if err != nil {
-				return fmt.Errorf("Error opening %s: %+v", path, err)
-			}
-			defer file.Close()
-
-			if _, err := io.Copy(tarWriter, file); err != nil {
-				return fmt.Errorf("Error copying %s: %+v", path, err)
-			}
-
-			return nil
-		})
-
-		if err != nil {
-			writer.CloseWithError(err)
-		}
+		writer.CloseWithError(writeTarball(packageDir, writer))
 	}()
 
 	return reader, nil
 }
 
-// Opens a writer for the given artifact into the workspace package cache
+// Opens a writer for the given artifact into the workspace package cache. The artifact is extracted
+// into a hidden temporary directory, and only moved into place once the writer is closed and the
+// whole tarball was extracted, so the package cache never contains a partially extracted artifact
 func (l *localManager) OpenWriter(artifact *model.Artifact) (io.WriteCloser, error) {
 	artifactDirName := localArtifactCacheDir(l.workspace, artifact)
-	if err := os.RemoveAll(artifactDirName); err != nil {
-		return nil, fmt.Errorf("Error removing existing artifact at %s: %+v", artifactDirName, err)
+	if err := os.MkdirAll(filepath.Dir(artifactDirName), 0755); err != nil {
+		return nil, fmt.Errorf("Error creating artifact directory %s: %+v", filepath.Dir(artifactDirName), err)
+	}
+
+	tempDir, err := ioutil.TempDir(filepath.Dir(artifactDirName), "."+filepath.Base(artifactDirName)+"-")
+	if err != nil {
+		return nil, fmt.Errorf("Error creating temporary directory for %s: %+v", artifactDirName, err)
 	}
 
-	if err := os.MkdirAll(artifactDirName, 0755); err != nil {
-		return nil, fmt.Errorf("Error creating artifact director %s: %+v", artifactDirName, err)
+	if err := os.Chmod(tempDir, 0755); err != nil {
+		os.RemoveAll(tempDir)
+		return nil, fmt.Errorf("Error changing the mode of %s: %+v", tempDir, err)
 	}
 
 	reader, writer := io.Pipe()
+	cacheWriter := &cacheWriter{
+		PipeWriter: writer,
+		tempDir:    tempDir,
+		location:   artifactDirName,
+		extracted:  make(chan error, 1),
+	}
+
 	go func() {
-		gzipReader, err := gzip.NewReader(reader)
-		if err != nil {
-			reader.CloseWithError(fmt.Errorf("Error opening gzip reader: %+v", err))
-		}
+		err := extractTarball(reader, tempDir)
+		reader.CloseWithError(err)
+		cacheWriter.extracted <- err
+	}()
+
+	return cacheWriter, nil
+}
+
+// cacheWriter writes an artifact's tarball to the extraction of the artifact into a temporary
+// directory. Closing it waits for the extraction and moves the directory to the artifact's location
+type cacheWriter struct {
+	*io.PipeWriter
+	tempDir   string
+	location  string
+	extracted chan error
+	closed    bool
+	err       error
+}
 
-		defer reader.Close()
-		defer gzipReader.Close()
-
-		tarReader := tar.NewReader(gzipReader)
-		for {
-			header, err := tarReader.Next()
-			if err == io.EOF {
-				return
-			}
-
-			if err != nil {
-				reader.CloseWithError(fmt.Errorf("Error reading tar header for %s: %+v", artifactDirName, err))
-				return
-			}
-
-			if header == nil {
-				continue
-			}
-
-			destination := filepath.Join(artifactDirName, header.Name)
-			if header.Typeflag == tar.TypeDir {
-				if err := os.MkdirAll(destination, 0755); err != nil {
-					reader.CloseWithError(fmt.Errorf("Error creating directory %s: %+v", destination, err))
-					return
-				}
-				continue
-			} else if header.Typeflag == tar.TypeReg {
-				flags := os.O_CREATE | os.O_EXCL | os.O_WRONLY
-				file, err := os.OpenFile(destination, flags, os.FileMode(header.Mode))
-				if err != nil {
-					reader.CloseWithError(fmt.Errorf("Error opening file %s: %+v", destination, err))
-					return
-				}
-
-				if _, err := io.Copy(file, tarReader); err != nil {
-					reader.CloseWithError(fmt.Errorf("Error copying file %s: %+v", destination, err))
-					file.Close()
-					return
-				}
-				file.Close()
-			} else {
-				buildlog.Debugf("Unknown header typeflag %.2x", header.Typeflag)
-			}
+func (c *cacheWriter) Close() error {
+	if c.closed {
+		return c.err
+	}
+	c.closed = true
+	defer os.RemoveAll(c.tempDir)
+
+	c.PipeWriter.Close()
+	if c.err = <-c.extracted; c.err != nil {
+		return c.err
+	}
+
+	if err := os.Rename(c.tempDir, c.location); err != nil {
+		// Another process may have finished downloading the same artifact first
+		if _, statErr := os.Stat(c.location); statErr == nil {
+			buildlog.Debugf("%s was extracted by someone else, keeping it", c.location)
+			return nil
 		}
-	}()
 
-	return writer, nil
+		c.err = fmt.Errorf("Error moving %s to %s: %+v", c.tempDir, c.location, err)
+	}
+
+	return c.err
 }
 
 func (l *localManager) PersistMetadata(writer io.Writer) error {
@@ -249,6 +187,12 @@ func GetRemoteManager(directory string) (artifacts.Manager, error) {
 		return nil, fmt.Errorf("Error getting workspace for %s: %+v", directory, err)
 	}
 
+	if offline, err := IsOffline(workspace); err != nil {
+		return nil, err
+	} else if offline {
+		return nil, ErrOffline
+	}
+
 	workspaceMetadata, err := GetWorkspaceMetadata(workspace)
 	if err != nil {
 		return nil, fmt.Errorf("Error getting workspace metadata for %s: %+v", workspace, err)
@@ -274,14 +218,45 @@ func GetRemoteManager(directory string) (artifacts.Manager, error) {
 
 // GetRemoteSourceSet returns the source set configured for the workspace directory
 func GetRemoteSourceSet(directory string) (artifacts.SourceSet, error) {
-	workspace, err := GetWorkspace(directory)
+	workspaceMetadata, err := getOnlineWorkspaceMetadata(directory)
 	if err != nil {
-		return nil, fmt.Errorf("Error getting workspace for %s: %+v", directory, err)
+		return nil, err
 	}
 
-	workspaceMetadata, err := GetWorkspaceMetadata(workspace)
+	return GetNamedRemoteSourceSet(directory, workspaceMetadata.SourceSetName)
+}
+
+// GetRemoteSourceSetLayers returns the source sets layered below the workspace's source set, from
+// highest to lowest
+func GetRemoteSourceSetLayers(directory string) ([]artifacts.SourceSet, error) {
+	workspaceMetadata, err := getOnlineWorkspaceMetadata(directory)
 	if err != nil {
-		return nil, fmt.Errorf("Error getting workspace metadata for %s: %+v", workspace, err)
+		return nil, err
+	}
+
+	layers := make([]artifacts.SourceSet, 0, len(workspaceMetadata.SourceSetLayers))
+	for _, name := range workspaceMetadata.SourceSetLayers {
+		layer, err := GetNamedRemoteSourceSet(directory, name)
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, layer)
+	}
+
+	return layers, nil
+}
+
+// GetNamedRemoteSourceSet returns the source set with the given name in the backend configured for the
+// workspace directory
+func GetNamedRemoteSourceSet(directory, name string) (artifacts.SourceSet, error) {
+	workspaceMetadata, err := getOnlineWorkspaceMetadata(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	workspace, err := GetWorkspace(directory)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting workspace for %s: %+v", directory, err)
 	}
 
 	sourceSetMetadataFile, err := os.Open(filepath.Join(workspace, workspaceDirName, sourceSetFileName))
@@ -297,10 +272,30 @@ func GetRemoteSourceSet(directory string) (artifacts.SourceSet, error) {
 			return nil, fmt.Errorf("Error decoding manager metadata: %+v", err)
 		}
 		session := NewSession(metadata.Region, metadata.Profile)
-		return artifacts.NewDynamoSourceSetFromMetadata(dynamodb.New(session),
-			workspaceMetadata.SourceSetName,
-			metadata)
+		return artifacts.NewDynamoSourceSetFromMetadata(dynamodb.New(session), name, metadata)
 	default:
 		return nil, fmt.Errorf("Unknown source set type found in metadata: %s", workspaceMetadata.SourceSetType)
 	}
 }
+
+// getOnlineWorkspaceMetadata returns the metadata of the workspace containing the directory, or
+// ErrOffline if remote source sets must not be used
+func getOnlineWorkspaceMetadata(directory string) (*WorkspaceMetadata, error) {
+	workspace, err := GetWorkspace(directory)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting workspace for %s: %+v", directory, err)
+	}
+
+	if offline, err := IsOffline(workspace); err != nil {
+		return nil, err
+	} else if offline {
+		return nil, ErrOffline
+	}
+
+	workspaceMetadata, err := GetWorkspaceMetadata(workspace)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting workspace metadata for %s: %+v", workspace, err)
+	}
+
+	return workspaceMetadata, nil
+}
@@ -0,0 +1,273 @@
+package local
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+
+	"github.com/dimes/zbuild/artifacts"
+	"github.com/dimes/zbuild/buildlog"
+	"github.com/dimes/zbuild/model"
+)
+
+const (
+	// RepositoryManagerType is the type identifier for managers backed by the user's local repository
+	RepositoryManagerType = "repository"
+
+	// RepositorySourceSetType is the type identifier for the user's local repository source set
+	RepositorySourceSetType = "repository"
+
+	// UserDirEnv is the environment variable that overrides the location of the user-level zbuild
+	// directory. It defaults to ~/.zbuild
+	UserDirEnv = "ZBUILD_HOME"
+
+	userDirName             = ".zbuild"
+	repositoryDirName       = "repository"
+	repositoryIndexFileName = "index.json"
+	repositoryArtifactExt   = ".tar.gz"
+)
+
+// GetUserDir returns the user-level zbuild directory. This directory is shared by all workspaces
+// belonging to the user
+func GetUserDir() (string, error) {
+	if userDir := os.Getenv(UserDirEnv); userDir != "" {
+		return filepath.Abs(userDir)
+	}
+
+	home := os.Getenv("HOME")
+	if home == "" {
+		currentUser, err := user.Current()
+		if err != nil {
+			return "", fmt.Errorf("Error determining home directory: %+v", err)
+		}
+		home = currentUser.HomeDir
+	}
+
+	return filepath.Join(home, userDirName), nil
+}
+
+func getRepositoryDir() (string, error) {
+	userDir, err := GetUserDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(userDir, repositoryDirName), nil
+}
+
+type repositoryManager struct {
+	root string
+}
+
+// GetRepositoryManager returns a manager that stores artifact tarballs in the user's local
+// repository. Artifacts published here are only visible to the current user
+func GetRepositoryManager() (artifacts.Manager, error) {
+	root, err := getRepositoryDir()
+	if err != nil {
+		return nil, fmt.Errorf("Error getting local repository directory: %+v", err)
+	}
+
+	return &repositoryManager{root: root}, nil
+}
+
+func (r *repositoryManager) Type() string {
+	return RepositoryManagerType
+}
+
+func (r *repositoryManager) Setup() error {
+	return os.MkdirAll(r.root, 0755)
+}
+
+func (r *repositoryManager) OpenReader(artifact *model.Artifact) (io.ReadCloser, error) {
+	location := repositoryArtifactLocation(r.root, artifact)
+	file, err := os.Open(location)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening local repository artifact %s: %+v", location, err)
+	}
+
+	return file, nil
+}
+
+func (r *repositoryManager) OpenWriter(artifact *model.Artifact) (io.WriteCloser, error) {
+	location := repositoryArtifactLocation(r.root, artifact)
+	if err := os.MkdirAll(filepath.Dir(location), 0755); err != nil {
+		return nil, fmt.Errorf("Error creating local repository directory for %s: %+v", location, err)
+	}
+
+	file, err := os.OpenFile(location, openFlags, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening local repository artifact %s: %+v", location, err)
+	}
+
+	return file, nil
+}
+
+func (r *repositoryManager) PersistMetadata(writer io.Writer) error {
+	return nil
+}
+
+func repositoryArtifactLocation(root string, artifact *model.Artifact) string {
+	return filepath.Join(root, artifact.Namespace, artifact.Name, artifact.Version,
+		artifact.BuildNumber+repositoryArtifactExt)
+}
+
+// repositorySourceSet is the overlay of locally published artifacts. At most one build of each
+// namespace/name/version is in use at a time, and the in-use builds are recorded in an index file
+type repositorySourceSet struct {
+	localSourceSet
+	root string
+}
+
+// GetRepositorySourceSet returns the source set containing the artifacts published to the user's
+// local repository
+func GetRepositorySourceSet() (artifacts.SourceSet, error) {
+	return getRepositorySourceSet()
+}
+
+func getRepositorySourceSet() (*repositorySourceSet, error) {
+	root, err := getRepositoryDir()
+	if err != nil {
+		return nil, fmt.Errorf("Error getting local repository directory: %+v", err)
+	}
+
+	artifacts, err := readRepositoryIndex(root)
+	if err != nil {
+		return nil, err
+	}
+
+	return &repositorySourceSet{
+		localSourceSet: *newLocalSourceSet("", repositoryDirName, artifacts),
+		root:           root,
+	}, nil
+}
+
+func (r *repositorySourceSet) Type() string {
+	return RepositorySourceSetType
+}
+
+func (r *repositorySourceSet) Setup() error {
+	return os.MkdirAll(r.root, 0755)
+}
+
+// RegisterArtifact is a no-op because the repository manager has already stored the artifact
+func (r *repositorySourceSet) RegisterArtifact(artifact *model.Artifact) error {
+	return nil
+}
+
+// UseArtifact records the artifact in the index, replacing and deleting any previous local build
+// of the same namespace/name/version
+func (r *repositorySourceSet) UseArtifact(artifact *model.Artifact) error {
+	artifacts, err := r.removeArtifacts(func(existing *model.Artifact) bool {
+		return existing.Namespace == artifact.Namespace &&
+			existing.Name == artifact.Name &&
+			existing.Version == artifact.Version &&
+			existing.BuildNumber != artifact.BuildNumber
+	})
+	if err != nil {
+		return err
+	}
+
+	return r.writeIndex(append(artifacts, artifact))
+}
+
+// clear removes every artifact that matches the given namespace, name and version. Empty values
+// match anything. The removed artifacts are returned
+func (r *repositorySourceSet) clear(namespace, name, version string) ([]*model.Artifact, error) {
+	removed := make([]*model.Artifact, 0)
+	remaining, err := r.removeArtifacts(func(artifact *model.Artifact) bool {
+		matches := (namespace == "" || artifact.Namespace == namespace) &&
+			(name == "" || artifact.Name == name) &&
+			(version == "" || artifact.Version == version)
+		if matches {
+			removed = append(removed, artifact)
+		}
+		return matches
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return removed, r.writeIndex(remaining)
+}
+
+// removeArtifacts deletes the tarballs of all artifacts matching the predicate and returns the
+// artifacts that should remain in the index
+func (r *repositorySourceSet) removeArtifacts(predicate func(*model.Artifact) bool) ([]*model.Artifact, error) {
+	remaining := make([]*model.Artifact, 0)
+	for _, artifact := range r.artifacts {
+		if !predicate(artifact) {
+			remaining = append(remaining, artifact)
+			continue
+		}
+
+		location := repositoryArtifactLocation(r.root, artifact)
+		buildlog.Debugf("Removing local repository artifact %s", location)
+		if err := os.Remove(location); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("Error removing %s: %+v", location, err)
+		}
+	}
+
+	return remaining, nil
+}
+
+func (r *repositorySourceSet) writeIndex(artifacts []*model.Artifact) error {
+	if err := os.MkdirAll(r.root, 0755); err != nil {
+		return fmt.Errorf("Error creating local repository %s: %+v", r.root, err)
+	}
+
+	indexLocation := filepath.Join(r.root, repositoryIndexFileName)
+	indexFile, err := os.OpenFile(indexLocation, openFlags, 0644)
+	if err != nil {
+		return fmt.Errorf("Error opening local repository index %s: %+v", indexLocation, err)
+	}
+	defer indexFile.Close()
+
+	if err := json.NewEncoder(indexFile).Encode(artifacts); err != nil {
+		return fmt.Errorf("Error writing local repository index %s: %+v", indexLocation, err)
+	}
+
+	r.localSourceSet = *newLocalSourceSet("", repositoryDirName, artifacts)
+	return nil
+}
+
+func readRepositoryIndex(root string) ([]*model.Artifact, error) {
+	indexLocation := filepath.Join(root, repositoryIndexFileName)
+	indexFile, err := os.Open(indexLocation)
+	if os.IsNotExist(err) {
+		return make([]*model.Artifact, 0), nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Error opening local repository index %s: %+v", indexLocation, err)
+	}
+	defer indexFile.Close()
+
+	artifacts := make([]*model.Artifact, 0)
+	if err := json.NewDecoder(indexFile).Decode(&artifacts); err != nil {
+		return nil, fmt.Errorf("Error decoding local repository index %s: %+v", indexLocation, err)
+	}
+
+	return artifacts, nil
+}
+
+// GetLocalPublishes returns every artifact currently published to the user's local repository
+func GetLocalPublishes() ([]*model.Artifact, error) {
+	repositorySourceSet, err := getRepositorySourceSet()
+	if err != nil {
+		return nil, err
+	}
+
+	return repositorySourceSet.GetAllArtifacts()
+}
+
+// ClearLocalPublishes removes locally published artifacts matching the namespace, name and version.
+// Empty values match anything, so passing all empty strings clears the local repository
+func ClearLocalPublishes(namespace, name, version string) ([]*model.Artifact, error) {
+	repositorySourceSet, err := getRepositorySourceSet()
+	if err != nil {
+		return nil, err
+	}
+
+	return repositorySourceSet.clear(namespace, name, version)
+}
@@ -0,0 +1,129 @@
+package local
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/dimes/zbuild/model"
+)
+
+const (
+	// CompileResolverName is the name of the compile dependency resolver
+	CompileResolverName = "compile"
+
+	// TestResolverName is the name of the test dependency resolver
+	TestResolverName = "test"
+
+	// RuntimeResolverName is the name of the runtime dependency resolver
+	RuntimeResolverName = "runtime"
+
+	// ToolResolverName is the name of the tool dependency resolver
+	ToolResolverName = "tool"
+
+	// ProvidedResolverName is the name of the provided dependency resolver
+	ProvidedResolverName = "provided"
+)
+
+var (
+	// CompileDependencyResolver resolves compile-time dependencies. Provided dependencies of the root
+	// package are included because they are needed to compile it
+	CompileDependencyResolver DependencyResolver = &scopeResolver{
+		rootScopes:       []model.Scope{model.ScopeCompile, model.ScopeProvided},
+		transitiveScopes: []model.Scope{model.ScopeCompile},
+	}
+
+	// TestDependencyResolver resolves test-dependencies. Tests compile and run the package, so this
+	// includes everything the compile and runtime resolvers do. Test dependencies are followed for
+	// every package in the closure
+	TestDependencyResolver DependencyResolver = &scopeResolver{
+		rootScopes: []model.Scope{model.ScopeCompile, model.ScopeProvided, model.ScopeRuntime,
+			model.ScopeTest},
+		transitiveScopes: []model.Scope{model.ScopeCompile, model.ScopeRuntime, model.ScopeTest},
+	}
+
+	// RuntimeDependencyResolver resolves the dependencies needed to run the package. Provided
+	// dependencies are excluded because the environment supplies them
+	RuntimeDependencyResolver DependencyResolver = &scopeResolver{
+		rootScopes:       []model.Scope{model.ScopeCompile, model.ScopeRuntime},
+		transitiveScopes: []model.Scope{model.ScopeCompile, model.ScopeRuntime},
+	}
+
+	// ToolDependencyResolver resolves the tools used while building the package, along with whatever
+	// the tools need to run
+	ToolDependencyResolver DependencyResolver = &scopeResolver{
+		rootScopes:       []model.Scope{model.ScopeTool},
+		transitiveScopes: []model.Scope{model.ScopeCompile, model.ScopeRuntime},
+	}
+
+	// ProvidedDependencyResolver resolves the dependencies the package expects its environment to provide
+	ProvidedDependencyResolver DependencyResolver = &scopeResolver{
+		rootScopes:       []model.Scope{model.ScopeProvided},
+		transitiveScopes: []model.Scope{model.ScopeCompile},
+	}
+
+	dependencyResolvers = map[string]DependencyResolver{
+		CompileResolverName:  CompileDependencyResolver,
+		TestResolverName:     TestDependencyResolver,
+		RuntimeResolverName:  RuntimeDependencyResolver,
+		ToolResolverName:     ToolDependencyResolver,
+		ProvidedResolverName: ProvidedDependencyResolver,
+	}
+)
+
+// DependencyResolver resolves different types of dependencies, e.g. test, compile, runtime, etc.
+type DependencyResolver interface {
+	GetDependencies(target model.Package) []model.Package
+}
+
+// ScopedDependencyResolver is implemented by resolvers that follow dependency scopes. Resolvers that
+// only implement DependencyResolver have GetDependencies called for every package in the closure
+type ScopedDependencyResolver interface {
+	DependencyResolver
+
+	// Scopes returns the scopes of the dependencies to follow from a package. root is true for the
+	// package whose dependencies are being resolved, and false for its transitive dependencies
+	Scopes(root bool) []model.Scope
+}
+
+// GetDependencyResolver returns the dependency resolver with the given name, e.g. compile or test
+func GetDependencyResolver(name string) (DependencyResolver, error) {
+	resolver, ok := dependencyResolvers[name]
+	if !ok {
+		return nil, fmt.Errorf("Could not find dependency resolver of type %s. Expected one of %v",
+			name, GetDependencyResolverNames())
+	}
+
+	return resolver, nil
+}
+
+// GetDependencyResolverNames returns the names of all dependency resolvers, sorted alphabetically
+func GetDependencyResolverNames() []string {
+	names := make([]string, 0, len(dependencyResolvers))
+	for name := range dependencyResolvers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type scopeResolver struct {
+	rootScopes       []model.Scope
+	transitiveScopes []model.Scope
+}
+
+// GetDependencies returns the dependencies followed from the target when it is the package being
+// resolved
+func (s *scopeResolver) GetDependencies(target model.Package) []model.Package {
+	dependencies := make([]model.Package, 0)
+	for _, scope := range s.rootScopes {
+		dependencies = append(dependencies, target.Dependencies.ForScope(scope)...)
+	}
+	return dependencies
+}
+
+func (s *scopeResolver) Scopes(root bool) []model.Scope {
+	if root {
+		return s.rootScopes
+	}
+	return s.transitiveScopes
+}
@@ -5,7 +5,10 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/dimes/zbuild/artifacts"
 	"github.com/dimes/zbuild/buildlog"
@@ -32,6 +35,15 @@ func NewLocalSourceSet(directory string) (artifacts.SourceSet, error) {
 		return nil, fmt.Errorf("Error getting workspace directory for %s: %+v", directory, err)
 	}
 
+	localSourceSet, err := newWorkspaceSourceSet(workspace)
+	if err != nil {
+		return nil, err
+	}
+
+	return localSourceSet, nil
+}
+
+func newWorkspaceSourceSet(workspace string) (*localSourceSet, error) {
 	workspaceMetadata, err := GetWorkspaceMetadata(workspace)
 	if err != nil {
 		return nil, fmt.Errorf("Error getting workspace metadata for source set: %+v", err)
@@ -47,30 +59,18 @@ func newOverrideSourceSet(directory string) (*overrideSourceSet, error) {
 		return nil, fmt.Errorf("Error getting workspace directory for %s: %+v", directory, err)
 	}
 
-	files, err := ioutil.ReadDir(workspace)
+	workspacePackages, err := findWorkspacePackages(workspace)
 	if err != nil {
-		return nil, fmt.Errorf("Error listing workspace %s: %+v", workspace, err)
+		return nil, err
 	}
 
 	artifacts := make([]*model.Artifact, 0)
 	overrideLocations := make(map[string]string)
-	for _, file := range files {
-		if !file.IsDir() {
-			continue
-		}
-
-		packagePath := filepath.Join(workspace, file.Name())
-		buildfilePath := filepath.Join(packagePath, model.BuildfileName)
-		parsedBuildfile, err := model.ParseBuildfile(buildfilePath)
-		if err != nil {
-			buildlog.Debugf("Ignoring possible override %s: %+v", buildfilePath, err)
-			continue
-		}
-
+	for _, parsedBuildfile := range workspacePackages {
 		artifacts = append(artifacts, &model.Artifact{
 			Package: parsedBuildfile.Package,
 		})
-		overrideLocations[packageToMapKey(parsedBuildfile.Package)] = packagePath
+		overrideLocations[packageToMapKey(parsedBuildfile.Package)] = parsedBuildfile.AbsoluteWorkingDir
 	}
 
 	workspaceMetadata, err := GetWorkspaceMetadata(workspace)
@@ -85,6 +85,122 @@ func newOverrideSourceSet(directory string) (*overrideSourceSet, error) {
 	}, nil
 }
 
+// GetWorkspacePackages returns the parsed build files of all packages checked out in the workspace
+// containing the directory, ordered by their location
+func GetWorkspacePackages(directory string) ([]*model.ParsedBuildfile, error) {
+	workspace, err := GetWorkspace(directory)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting workspace directory for %s: %+v", directory, err)
+	}
+
+	return findWorkspacePackages(workspace)
+}
+
+// GetPackageDir returns the directory of the package containing the path, i.e. the closest directory
+// at or above the path that contains a build file. The directory must be inside a workspace
+func GetPackageDir(path string) (string, error) {
+	workspace, err := GetWorkspace(path)
+	if err != nil {
+		return "", fmt.Errorf("Error getting workspace directory for %s: %+v", path, err)
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("Error determining absolute path for %s: %+v", path, err)
+	}
+
+	for dir := abs; dir != workspace && dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		if info, err := os.Stat(filepath.Join(dir, model.BuildfileName)); err == nil && !info.IsDir() {
+			return dir, nil
+		}
+	}
+
+	return "", fmt.Errorf("No %s found between %s and the workspace %s", model.BuildfileName, abs, workspace)
+}
+
+// findWorkspacePackages looks for build files below the workspace and the package roots in the
+// workspace config, up to the configured depth. Hidden and ignored directories are skipped, and the
+// search doesn't descend into packages, so a package's own files are never mistaken for packages
+func findWorkspacePackages(workspace string) ([]*model.ParsedBuildfile, error) {
+	workspaceConfig, err := GetWorkspaceConfig(workspace)
+	if err != nil {
+		return nil, err
+	}
+
+	// The package roots are searched before the workspace, so their packages can be deeper than the
+	// max depth below the workspace
+	roots := make([]string, 0, len(workspaceConfig.PackageRoots)+1)
+	for _, root := range workspaceConfig.PackageRoots {
+		roots = append(roots, filepath.Join(workspace, root))
+	}
+	roots = append(roots, workspace)
+
+	workspacePackages := make([]*model.ParsedBuildfile, 0)
+	searched := make(map[string]bool)
+	var search func(dir string, depth int) error
+	search = func(dir string, depth int) error {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return fmt.Errorf("Error listing %s: %+v", dir, err)
+		}
+
+		for _, file := range files {
+			childDir := filepath.Join(dir, file.Name())
+			if !file.IsDir() || strings.HasPrefix(file.Name(), ".") || searched[childDir] {
+				continue
+			}
+			searched[childDir] = true
+
+			relativeDir, err := filepath.Rel(workspace, childDir)
+			if err != nil {
+				return fmt.Errorf("Error getting %s relative to %s: %+v", childDir, workspace, err)
+			}
+
+			if workspaceConfig.isIgnoredPackageDir(relativeDir) {
+				buildlog.Debugf("Ignoring %s because it matches packageIgnore", childDir)
+				continue
+			}
+
+			buildfilePath := filepath.Join(childDir, model.BuildfileName)
+			if _, err := os.Stat(buildfilePath); err == nil {
+				parsedBuildfile, err := model.ParseBuildfile(buildfilePath)
+				if err != nil {
+					buildlog.Debugf("Ignoring possible override %s: %+v", buildfilePath, err)
+					continue
+				}
+
+				workspacePackages = append(workspacePackages, parsedBuildfile)
+				continue
+			}
+
+			if depth < workspaceConfig.GetPackageMaxDepth() {
+				if err := search(childDir, depth+1); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	}
+
+	for _, root := range roots {
+		if _, err := os.Stat(root); os.IsNotExist(err) {
+			buildlog.Debugf("Ignoring package root %s because it doesn't exist", root)
+			continue
+		}
+
+		if err := search(root, 1); err != nil {
+			return nil, fmt.Errorf("Error searching package root %s: %+v", root, err)
+		}
+	}
+
+	sort.Slice(workspacePackages, func(i, j int) bool {
+		return workspacePackages[i].AbsoluteWorkingDir < workspacePackages[j].AbsoluteWorkingDir
+	})
+
+	return workspacePackages, nil
+}
+
 func newLocalSourceSet(workspace, name string, artifacts []*model.Artifact) *localSourceSet {
 	artifactIndex := make(map[string]map[string]map[string]*model.Artifact)
 	for _, artifact := range artifacts {
@@ -146,6 +262,15 @@ func (l *localSourceSet) GetArtifact(namespace, name, version string) (*model.Ar
 	return artifact, nil
 }
 
+// getVersions returns every version of the namespace/name present in the source set
+func (l *localSourceSet) getVersions(namespace, name string) []string {
+	versions := make([]string, 0)
+	for version := range l.artifactIndex[namespace][name] {
+		versions = append(versions, version)
+	}
+	return versions
+}
+
 func (l *localSourceSet) GetAllArtifacts() ([]*model.Artifact, error) {
 	return l.artifacts, nil
 }
@@ -0,0 +1,278 @@
+package local
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dimes/zbuild/artifacts"
+	"github.com/dimes/zbuild/model"
+	"github.com/dimes/zbuild/versions"
+)
+
+// BuildState describes the build directory of a package checked out in the workspace
+type BuildState string
+
+const (
+	// BuildStateNotBuilt means the package has no build directory, or it is empty
+	BuildStateNotBuilt BuildState = "not built"
+
+	// BuildStateStale means a source file is newer than everything in the build directory
+	BuildStateStale BuildState = "stale"
+
+	// BuildStateUpToDate means the build directory is newer than every source file
+	BuildStateUpToDate BuildState = "up to date"
+)
+
+var (
+	// statusResolvers are the closures checked for artifacts missing from the package cache
+	statusResolvers = []DependencyResolver{TestDependencyResolver, ToolDependencyResolver}
+)
+
+// WorkspaceStatus is an overview of the packages checked out in a workspace and its source set
+type WorkspaceStatus struct {
+	Packages []*PackageStatus
+
+	// Changes are the differences between the remote source set and the workspace metadata, i.e. what
+	// the next refresh would change. They are only checked when ChangesChecked is set, and
+	// ChangesError is set if the remote source set couldn't be read
+	Changes        []*SourceSetChange
+	ChangesChecked bool
+	ChangesError   error
+
+	// Missing are the artifacts in the closures of the workspace packages that aren't in the package
+	// cache yet, sorted by namespace/name/version
+	Missing []*model.Artifact
+}
+
+// PackageStatus describes a package checked out in the workspace
+type PackageStatus struct {
+	Buildfile *model.ParsedBuildfile
+
+	// SourceSetArtifact is the build of the same version in the source set, or nil if there is none.
+	// SourceSetVersions lists every version of the package in the source set
+	SourceSetArtifact *model.Artifact
+	SourceSetVersions []string
+
+	Build BuildState
+
+	// ResolveError is set if the closure of the package couldn't be walked to find missing artifacts
+	ResolveError error
+}
+
+// SourceSetChange is an artifact that was added to, removed from or rebuilt in the remote source set.
+// Previous is nil for additions and Current is nil for removals
+type SourceSetChange struct {
+	Previous *model.Artifact
+	Current  *model.Artifact
+}
+
+// String describes the change
+func (s *SourceSetChange) String() string {
+	switch {
+	case s.Previous == nil:
+		return fmt.Sprintf("added   %s build %s", s.Current.String(), s.Current.BuildNumber)
+	case s.Current == nil:
+		return fmt.Sprintf("removed %s build %s", s.Previous.String(), s.Previous.BuildNumber)
+	default:
+		return fmt.Sprintf("changed %s build %s -> %s", s.Current.String(), s.Previous.BuildNumber,
+			s.Current.BuildNumber)
+	}
+}
+
+// GetWorkspaceStatus returns the status of the workspace containing the directory. The remote source
+// set is only contacted when the workspace isn't offline, and nothing is downloaded
+func GetWorkspaceStatus(directory string) (*WorkspaceStatus, error) {
+	workspace, err := GetWorkspace(directory)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting workspace directory for %s: %+v", directory, err)
+	}
+
+	// Without an upstream manager nothing is downloaded, and missing artifacts are only recorded
+	buildpathGenerator, err := newLocalBuildpathGenerator(workspace)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting buildpath generator for %s: %+v", workspace, err)
+	}
+	buildpathGenerator.tolerateMissing = true
+	buildpathGenerator.cacheSize = 0
+	missing := make(map[string]*model.Artifact)
+
+	workspacePackages, err := findWorkspacePackages(workspace)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &WorkspaceStatus{
+		Packages: make([]*PackageStatus, 0, len(workspacePackages)),
+	}
+
+	for _, parsedBuildfile := range workspacePackages {
+		target := parsedBuildfile.Package
+		packageStatus := &PackageStatus{
+			Buildfile:         parsedBuildfile,
+			SourceSetVersions: buildpathGenerator.localSourceSet.getVersions(target.Namespace, target.Name),
+		}
+		versions.Sort(packageStatus.SourceSetVersions)
+
+		artifact, err := buildpathGenerator.localSourceSet.GetArtifact(target.Namespace, target.Name,
+			target.Version)
+		if err == nil {
+			packageStatus.SourceSetArtifact = artifact
+		} else if err != artifacts.ErrArtifactNotFound {
+			return nil, err
+		}
+
+		if packageStatus.Build, err = getBuildState(parsedBuildfile); err != nil {
+			return nil, err
+		}
+
+		packageStatus.ResolveError = buildpathGenerator.resolveForStatus(target, missing)
+		status.Packages = append(status.Packages, packageStatus)
+	}
+
+	for _, key := range sortedArtifactKeys(missing) {
+		status.Missing = append(status.Missing, missing[key])
+	}
+
+	remoteSourceSet, err := GetRemoteSourceSet(workspace)
+	if err == ErrOffline {
+		return status, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Error getting remote source set for %s: %+v", workspace, err)
+	}
+
+	layers, err := GetRemoteSourceSetLayers(workspace)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting source set layers for %s: %+v", workspace, err)
+	}
+
+	status.ChangesChecked = true
+	current, err := getLayeredArtifacts(append([]artifacts.SourceSet{remoteSourceSet}, layers...))
+	if err != nil {
+		status.ChangesError = err
+		return status, nil
+	}
+
+	status.Changes = diffArtifacts(buildpathGenerator.localSourceSet.artifacts, current)
+	return status, nil
+}
+
+// resolveForStatus resolves every closure of the target that a build needs, the same way a build
+// does. Artifacts that aren't in the package cache are added to missing
+func (b *buildpathGenerator) resolveForStatus(target model.Package, missing map[string]*model.Artifact) error {
+	if err := b.useLockfile(target); err != nil {
+		return err
+	}
+
+	for _, resolver := range statusResolvers {
+		_, err := b.resolve(target, resolver, false)
+		for key, artifact := range b.missing {
+			missing[key] = artifact
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// diffArtifacts returns the artifacts that were added, removed or have a different build in current,
+// sorted by namespace/name/version
+func diffArtifacts(previous, current []*model.Artifact) []*SourceSetChange {
+	previousIndex := make(map[string]*model.Artifact)
+	for _, artifact := range previous {
+		previousIndex[packageToMapKey(artifact.Package)] = artifact
+	}
+
+	currentIndex := make(map[string]*model.Artifact)
+	for _, artifact := range current {
+		currentIndex[packageToMapKey(artifact.Package)] = artifact
+	}
+
+	changes := make([]*SourceSetChange, 0)
+	for key, artifact := range currentIndex {
+		old, ok := previousIndex[key]
+		if !ok {
+			changes = append(changes, &SourceSetChange{Current: artifact})
+		} else if old.BuildNumber != artifact.BuildNumber || old.Digest != artifact.Digest {
+			changes = append(changes, &SourceSetChange{Previous: old, Current: artifact})
+		}
+	}
+
+	for key, artifact := range previousIndex {
+		if _, ok := currentIndex[key]; !ok {
+			changes = append(changes, &SourceSetChange{Previous: artifact})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changeKey(changes[i]) < changeKey(changes[j])
+	})
+
+	return changes
+}
+
+func changeKey(change *SourceSetChange) string {
+	if change.Current != nil {
+		return packageToMapKey(change.Current.Package)
+	}
+	return packageToMapKey(change.Previous.Package)
+}
+
+// getBuildState compares the newest file in the package's build directory with its newest source
+// file. Hidden files and directories, e.g. .git, aren't considered sources
+func getBuildState(parsedBuildfile *model.ParsedBuildfile) (BuildState, error) {
+	buildDir := parsedBuildfile.AbsoluteBuildDir
+	newestOutput, err := newestModTime(buildDir, "")
+	if err != nil {
+		return "", err
+	} else if newestOutput.IsZero() {
+		return BuildStateNotBuilt, nil
+	}
+
+	newestSource, err := newestModTime(parsedBuildfile.AbsoluteWorkingDir, buildDir)
+	if err != nil {
+		return "", err
+	}
+
+	if newestSource.After(newestOutput) {
+		return BuildStateStale, nil
+	}
+
+	return BuildStateUpToDate, nil
+}
+
+// newestModTime returns the modification time of the newest file below the directory, skipping the
+// excluded directory and hidden files. The zero time is returned if there are no files
+func newestModTime(dir, excluded string) (time.Time, error) {
+	var newest time.Time
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) && path == dir {
+			return filepath.SkipDir
+		} else if err != nil {
+			return err
+		}
+
+		if path != dir && (path == excluded || strings.HasPrefix(info.Name(), ".")) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !info.IsDir() && info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+		return nil
+	})
+	if err != nil {
+		return newest, fmt.Errorf("Error listing %s: %+v", dir, err)
+	}
+
+	return newest, nil
+}
@@ -0,0 +1,116 @@
+package local
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/dimes/zbuild/buildlog"
+)
+
+// writeTarball writes the contents of the directory to the writer as a gzipped tarball. Paths in the
+// tarball are relative to the directory
+func writeTarball(dir string, writer io.Writer) error {
+	gzipWriter := gzip.NewWriter(writer)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if path == dir {
+			return nil
+		}
+
+		header, err := tar.FileInfoHeader(info, info.Name())
+		if err != nil {
+			return fmt.Errorf("Error creating file info header for %s: %+v", path, err)
+		}
+
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return fmt.Errorf("Error getting %s relative to %s: %+v", path, dir, err)
+		}
+		header.Name = name
+
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return fmt.Errorf("Error writing header for %s: %+v", path, err)
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("Error opening %s: %+v", path, err)
+		}
+		defer file.Close()
+
+		if _, err := io.Copy(tarWriter, file); err != nil {
+			return fmt.Errorf("Error copying %s: %+v", path, err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		return fmt.Errorf("Error closing tar writer: %+v", err)
+	}
+
+	return gzipWriter.Close()
+}
+
+// extractTarball extracts a gzipped tarball written by writeTarball into the directory
+func extractTarball(reader io.Reader, dir string) error {
+	gzipReader, err := gzip.NewReader(reader)
+	if err != nil {
+		return fmt.Errorf("Error opening gzip reader: %+v", err)
+	}
+	defer gzipReader.Close()
+
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("Error reading tar header for %s: %+v", dir, err)
+		}
+
+		if header == nil {
+			continue
+		}
+
+		destination := filepath.Join(dir, header.Name)
+		if header.Typeflag == tar.TypeDir {
+			if err := os.MkdirAll(destination, 0755); err != nil {
+				return fmt.Errorf("Error creating directory %s: %+v", destination, err)
+			}
+			continue
+		} else if header.Typeflag == tar.TypeReg {
+			flags := os.O_CREATE | os.O_EXCL | os.O_WRONLY
+			file, err := os.OpenFile(destination, flags, os.FileMode(header.Mode))
+			if err != nil {
+				return fmt.Errorf("Error opening file %s: %+v", destination, err)
+			}
+
+			if _, err := io.Copy(file, tarReader); err != nil {
+				file.Close()
+				return fmt.Errorf("Error copying file %s: %+v", destination, err)
+			}
+			file.Close()
+		} else {
+			buildlog.Debugf("Unknown header typeflag %.2x", header.Typeflag)
+		}
+	}
+}
@@ -18,6 +18,11 @@ type WorkspaceMetadata struct {
 	SourceSetType string
 	ManagerType   string
 	Artifacts     []*model.Artifact
+
+	// SourceSetLayers are the source sets below SourceSetName, from highest to lowest. Artifacts
+	// contains the builds of every layer, and a higher layer's build of a namespace/name/version
+	// overrides the builds of lower layers
+	SourceSetLayers []string `json:",omitempty"`
 }
 
 const (
@@ -35,8 +40,10 @@ var (
 	ErrWorkspaceNotFound = errors.New("workspace not found")
 )
 
-// InitWorkspace creates a new workspace at the specified location
-func InitWorkspace(location string, sourceSet artifacts.SourceSet, manager artifacts.Manager) error {
+// InitWorkspace creates a new workspace at the specified location. Any layers are used below the
+// source set, from highest to lowest
+func InitWorkspace(location string, sourceSet artifacts.SourceSet, manager artifacts.Manager,
+	layers ...artifacts.SourceSet) error {
 	workspaceDir := filepath.Join(location, workspaceDirName)
 	if info, _ := os.Stat(workspaceDir); info != nil {
 		return fmt.Errorf("Found existing workspace directory at %s", workspaceDir)
@@ -77,7 +84,7 @@ func InitWorkspace(location string, sourceSet artifacts.SourceSet, manager artif
 		return fmt.Errorf("Error persisting manager: %+v", err)
 	}
 
-	if err := RefreshWorkspace(location, sourceSet); err != nil {
+	if err := RefreshWorkspace(location, sourceSet, layers...); err != nil {
 		os.RemoveAll(workspaceDir)
 		return err
 	}
@@ -85,29 +92,68 @@ func InitWorkspace(location string, sourceSet artifacts.SourceSet, manager artif
 	return nil
 }
 
-// RefreshWorkspace refreshes the workspace metadata for the workspace located at location
-func RefreshWorkspace(location string, sourceSet artifacts.SourceSet) error {
+// RefreshWorkspace refreshes the workspace metadata for the workspace located at location. The
+// workspace is pointed at the source set and layers, which may differ from the ones it used before.
+// Layers are ordered from highest to lowest, and all of them are below the source set
+func RefreshWorkspace(location string, sourceSet artifacts.SourceSet, layers ...artifacts.SourceSet) error {
 	oldWorkspaceMetadata, err := GetWorkspaceMetadata(location)
 	if err != nil {
 		return fmt.Errorf("Error getting existing workspace metadata for %s: %+v", location, err)
 	}
 
-	artifacts, err := sourceSet.GetAllArtifacts()
+	layeredArtifacts, err := getLayeredArtifacts(append([]artifacts.SourceSet{sourceSet}, layers...))
 	if err != nil {
-		return fmt.Errorf("Error retrieving artifacts from source set %s: %+v", sourceSet.Name(), err)
+		return err
+	}
+
+	layerNames := make([]string, 0, len(layers))
+	for _, layer := range layers {
+		layerNames = append(layerNames, layer.Name())
 	}
 
 	workspaceMetadata := &WorkspaceMetadata{
-		SourceSetName: sourceSet.Name(),
-		Artifacts:     artifacts,
-		SourceSetType: oldWorkspaceMetadata.SourceSetType,
-		ManagerType:   oldWorkspaceMetadata.ManagerType,
+		SourceSetName:   sourceSet.Name(),
+		SourceSetLayers: layerNames,
+		Artifacts:       layeredArtifacts,
+		SourceSetType:   oldWorkspaceMetadata.SourceSetType,
+		ManagerType:     oldWorkspaceMetadata.ManagerType,
 	}
 
 	workspaceDir := filepath.Join(location, workspaceDirName)
 	return writeMetadata(workspaceMetadata, workspaceDir)
 }
 
+// getLayeredArtifacts returns the artifacts of every source set, which are ordered from highest to
+// lowest. Only the highest build of each namespace/name/version is kept
+func getLayeredArtifacts(sourceSets []artifacts.SourceSet) ([]*model.Artifact, error) {
+	layered := make([]*model.Artifact, 0)
+	seen := make(map[string]string)
+	for _, sourceSet := range sourceSets {
+		sourceSetArtifacts, err := sourceSet.GetAllArtifacts()
+		if err != nil {
+			return nil, fmt.Errorf("Error retrieving artifacts from source set %s: %+v", sourceSet.Name(), err)
+		}
+
+		if len(sourceSetArtifacts) == 0 {
+			buildlog.Warningf("Source set %s has no artifacts", sourceSet.Name())
+		}
+
+		for _, artifact := range sourceSetArtifacts {
+			key := packageToMapKey(artifact.Package)
+			if higher, ok := seen[key]; ok {
+				buildlog.Debugf("Ignoring the %s build of %s because %s overrides it", sourceSet.Name(), key,
+					higher)
+				continue
+			}
+
+			seen[key] = sourceSet.Name()
+			layered = append(layered, artifact)
+		}
+	}
+
+	return layered, nil
+}
+
 func writeMetadata(workspaceMetadata *WorkspaceMetadata, workspaceDir string) error {
 	metadataFileLocation := filepath.Join(workspaceDir, metadataFileName)
 	metadataFile, err := os.OpenFile(metadataFileLocation, openFlags, 0644)
@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/dimes/zbuild/buildlog"
 
@@ -16,13 +17,58 @@ const (
 	// BuildfileName is the one and only accepted name for buildfiles
 	BuildfileName = "build.yaml"
 
+	// LockfileName is the name of the file, next to the build file, that records the exact builds
+	// of a package's dependencies
+	LockfileName = "build.lock"
+
 	// BuildDir is the directory built artifacts are written to
 	BuildDir = "build"
+
+	// BinDir is the directory inside the build directory that executables are written to
+	BinDir = "bin"
+
+	// AnyVersion is the version constraint that matches every version
+	AnyVersion = "*"
+
+	// InUseVersion is the version of dependencies that don't declare one. It resolves to the version
+	// the workspace's source set has in use
+	InUseVersion = "in-use"
+
+	// ConflictPolicyFail fails the build when multiple versions of a package are in the dependency
+	// closure. This is the default policy
+	ConflictPolicyFail = "fail"
+
+	// ConflictPolicyNewest resolves conflicts by using the newest of the conflicting versions
+	ConflictPolicyNewest = "newest"
 )
 
 // Buildfile is what a package's build file is parsed into
 type Buildfile struct {
 	Package `yaml:",inline"` // The build file always contains a nested package
+
+	// Env sets environment variables for the package's build. Values may refer to other variables,
+	// e.g. $HOME, and ${PACKAGE_DIR} is the package's directory
+	Env map[string]string `yaml:"env,omitempty"`
+}
+
+// UnmarshalYAML parses the package along with the fields that only appear in build files. Without it,
+// the UnmarshalYAML of the embedded package would be used and those fields would be ignored
+func (b *Buildfile) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&b.Package); err != nil {
+		return err
+	}
+
+	// A separate type has no UnmarshalYAML, so its fields are parsed as usual
+	type buildfileFields struct {
+		Env map[string]string `yaml:"env,omitempty"`
+	}
+	fields := buildfileFields{}
+	if err := unmarshal(&fields); err != nil {
+		return err
+	}
+
+	b.Env = fields.Env
+	return nil
 }
 
 // ParsedBuildfile is like a Buildfile but contains meta-information about the input build file
@@ -42,7 +88,23 @@ type Package struct {
 	Version   string `yaml:"version"`   // The version of the package
 	Type      string `yaml:"type"`      // The type of package, e.g. go, java, etc.
 
+	// Repository is the URL of the git repository containing the package's source, e.g.
+	// git@github.com:org/repo.git or file:///srv/git/repo.git
+	Repository string `yaml:"repository,omitempty" json:",omitempty" dynamodbav:",omitempty"`
+
 	Dependencies Dependencies `yaml:"dependencies"` // The set of dependencies of this package
+
+	// Resolution controls how the dependency closure is resolved. It is only honored in the build file
+	// of the package being built
+	Resolution Resolution `yaml:"resolution,omitempty" json:",omitempty" dynamodbav:",omitempty"`
+
+	// Exclude lists packages that should not be pulled in by this package's transitive dependencies.
+	// On a dependency entry it applies to that dependency's subtree
+	Exclude []Exclusion `yaml:"exclude,omitempty" json:",omitempty" dynamodbav:",omitempty"`
+
+	// ExportEnv sets environment variables for the builds of packages that depend on this package at
+	// compile time or as a tool. ${PACKAGE_DIR} is this package's build output
+	ExportEnv map[string]string `yaml:"exportEnv,omitempty" json:",omitempty" dynamodbav:",omitempty"`
 }
 
 // String returns a human readable string representing this package
@@ -50,25 +112,192 @@ func (p Package) String() string {
 	return fmt.Sprintf("%s/%s-%s", p.Namespace, p.Name, p.Version)
 }
 
+// UnmarshalYAML allows packages to be written as namespace/name@version strings, e.g. in lists of
+// dependencies. See ParseReference
+func (p *Package) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var reference string
+	if err := unmarshal(&reference); err == nil {
+		parsed, err := ParseReference(reference)
+		if err != nil {
+			return err
+		}
+		*p = parsed
+		return nil
+	}
+
+	// The conversion prevents UnmarshalYAML from being called recursively
+	type rawPackage Package
+	raw := rawPackage{}
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+
+	*p = Package(raw)
+	return nil
+}
+
+// ParseReference parses a package reference of the form namespace/name@version. The namespace and
+// version are optional, e.g. name@^1.2 and namespace/name are valid references
+func ParseReference(reference string) (Package, error) {
+	pkg := Package{Name: strings.TrimSpace(reference)}
+	if at := strings.Index(pkg.Name, "@"); at >= 0 {
+		pkg.Name, pkg.Version = pkg.Name[:at], strings.TrimSpace(pkg.Name[at+1:])
+		if pkg.Version == "" {
+			return pkg, fmt.Errorf("Missing version after @ in package reference %q", reference)
+		}
+	}
+
+	if slash := strings.Index(pkg.Name, "/"); slash >= 0 {
+		pkg.Namespace, pkg.Name = pkg.Name[:slash], pkg.Name[slash+1:]
+	}
+
+	if pkg.Name == "" || strings.Contains(pkg.Name, "/") {
+		return pkg, fmt.Errorf("Invalid package reference %q. Expected namespace/name@version", reference)
+	}
+
+	return pkg, nil
+}
+
+// Qualify returns the reference with the fields that may be omitted in the package's build file
+// filled in. The namespace defaults to the package's namespace and the version to InUseVersion
+func (p Package) Qualify(reference Package) Package {
+	if reference.Namespace == "" {
+		reference.Namespace = p.Namespace
+	}
+
+	if reference.Version == "" {
+		reference.Version = InUseVersion
+	}
+
+	for i, exclusion := range reference.Exclude {
+		reference.Exclude[i] = p.qualifyExclusion(exclusion)
+	}
+
+	return reference
+}
+
+func (p Package) qualifyExclusion(exclusion Exclusion) Exclusion {
+	if exclusion.Namespace == "" {
+		exclusion.Namespace = p.Namespace
+	}
+	return exclusion
+}
+
+// qualifyReferences qualifies every package referenced by the package's build file
+func (p *Package) qualifyReferences() {
+	for _, scope := range Scopes {
+		dependencies := p.Dependencies.ForScope(scope)
+		for i, dependency := range dependencies {
+			dependencies[i] = p.Qualify(dependency)
+		}
+	}
+
+	for i, pin := range p.Resolution.Pins {
+		p.Resolution.Pins[i] = p.Qualify(pin)
+	}
+
+	for i, exclusion := range p.Exclude {
+		p.Exclude[i] = p.qualifyExclusion(exclusion)
+	}
+}
+
+// Scope identifies one of the lists of dependencies in a build file
+type Scope string
+
+const (
+	// ScopeCompile dependencies are required to compile the package and by its dependents
+	ScopeCompile Scope = "compile"
+
+	// ScopeTest dependencies are only required to test the package
+	ScopeTest Scope = "test"
+
+	// ScopeRuntime dependencies are required to run the package, but not to compile it
+	ScopeRuntime Scope = "runtime"
+
+	// ScopeTool dependencies provide executables, e.g. code generators, that are used during the build
+	ScopeTool Scope = "tool"
+
+	// ScopeProvided dependencies are required to compile the package, but are provided by the
+	// environment the package runs in
+	ScopeProvided Scope = "provided"
+)
+
+// Scopes contains every scope, in the order they are listed by Dependencies.All
+var Scopes = []Scope{ScopeCompile, ScopeTest, ScopeRuntime, ScopeTool, ScopeProvided}
+
 // Dependencies is a container struct for lists of different types of dependencies
 type Dependencies struct {
-	Test    []Package `yaml:"test"`
-	Compile []Package `yaml:"compile"`
+	Test     []Package `yaml:"test"`
+	Compile  []Package `yaml:"compile"`
+	Runtime  []Package `yaml:"runtime,omitempty"`
+	Tool     []Package `yaml:"tool,omitempty"`
+	Provided []Package `yaml:"provided,omitempty"`
+}
+
+// ForScope returns the dependencies declared with the given scope
+func (d *Dependencies) ForScope(scope Scope) []Package {
+	switch scope {
+	case ScopeCompile:
+		return d.Compile
+	case ScopeTest:
+		return d.Test
+	case ScopeRuntime:
+		return d.Runtime
+	case ScopeTool:
+		return d.Tool
+	case ScopeProvided:
+		return d.Provided
+	}
+	return nil
 }
 
 // All returns all dependencies, regardless of type
 func (d *Dependencies) All() []Package {
 	dependencies := make([]Package, 0)
-	dependencies = append(dependencies, d.Compile...)
-	dependencies = append(dependencies, d.Test...)
+	for _, scope := range Scopes {
+		dependencies = append(dependencies, d.ForScope(scope)...)
+	}
 	return dependencies
 }
 
+// Exclusion identifies a package that should be left out of a dependency subtree. All versions of
+// the package are excluded
+type Exclusion struct {
+	Namespace string `yaml:"namespace"`
+	Name      string `yaml:"name"`
+}
+
+// Matches returns true if the package is excluded by this exclusion
+func (e Exclusion) Matches(pkg Package) bool {
+	return e.Namespace == pkg.Namespace && e.Name == pkg.Name
+}
+
+// String returns a human readable string representing this exclusion
+func (e Exclusion) String() string {
+	return fmt.Sprintf("%s/%s", e.Namespace, e.Name)
+}
+
+// Resolution contains the options for resolving a package's dependency closure
+type Resolution struct {
+	// Conflicts is the conflict policy, i.e. fail or newest
+	Conflicts string `yaml:"conflicts,omitempty" json:",omitempty" dynamodbav:",omitempty"`
+
+	// Pins are the versions used for packages wherever they appear
+	Pins []Package `yaml:"pins,omitempty" json:",omitempty" dynamodbav:",omitempty"`
+}
+
 // Artifact represents a single build of a package. The build number must be unique across all
 // builds of the package
 type Artifact struct {
 	Package
 	BuildNumber string
+
+	// Digest is the digest of the artifact's tarball, e.g. sha256:2c26b46b... Artifacts published
+	// before digests were recorded don't have one
+	Digest string `json:",omitempty" dynamodbav:",omitempty"`
+
+	// Commit is the commit of the package's repository the artifact was built from, if known
+	Commit string `json:",omitempty" dynamodbav:",omitempty"`
 }
 
 // NewArtifact returns an artifact for the given package/build number
@@ -109,6 +338,8 @@ func ParseBuildfile(buildfilePath string) (*ParsedBuildfile, error) {
 		return nil, fmt.Errorf("Error parsing %s: %+v", buildfilePath, err)
 	}
 
+	buildfile.Package.qualifyReferences()
+
 	absoluteBuildfilePath, err := filepath.Abs(buildfilePath)
 	if err != nil {
 		return nil, fmt.Errorf("Error determining working directory: %+v", err)
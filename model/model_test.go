@@ -0,0 +1,156 @@
+package model
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseReference(t *testing.T) {
+	tests := []struct {
+		reference string
+		expected  Package
+		valid     bool
+	}{
+		{"ns/name@1.0", Package{Namespace: "ns", Name: "name", Version: "1.0"}, true},
+		{"name@^1.2", Package{Name: "name", Version: "^1.2"}, true},
+		{"ns/name", Package{Namespace: "ns", Name: "name"}, true},
+		{"name", Package{Name: "name"}, true},
+		{"ns/name@", Package{}, false},
+		{"ns/@1.0", Package{}, false},
+		{"a/b/c@1.0", Package{}, false},
+		{"", Package{}, false},
+	}
+
+	for _, test := range tests {
+		actual, err := ParseReference(test.reference)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("Expected %q to be invalid, got %+v", test.reference, actual)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Unexpected error parsing %q: %+v", test.reference, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("Parsed %q into %+v, expected %+v", test.reference, actual, test.expected)
+		}
+	}
+}
+
+func TestQualify(t *testing.T) {
+	pkg := Package{Namespace: "ns", Name: "app", Version: "1.0"}
+
+	tests := []struct {
+		reference Package
+		expected  Package
+	}{
+		{
+			Package{Namespace: "other", Name: "lib", Version: "2.0"},
+			Package{Namespace: "other", Name: "lib", Version: "2.0"},
+		},
+		{
+			Package{Name: "lib", Version: "2.0"},
+			Package{Namespace: "ns", Name: "lib", Version: "2.0"},
+		},
+		{
+			Package{Namespace: "other", Name: "lib"},
+			Package{Namespace: "other", Name: "lib", Version: InUseVersion},
+		},
+		{
+			Package{Name: "lib", Exclude: []Exclusion{{Name: "a"}, {Namespace: "other", Name: "b"}}},
+			Package{
+				Namespace: "ns",
+				Name:      "lib",
+				Version:   InUseVersion,
+				Exclude:   []Exclusion{{Namespace: "ns", Name: "a"}, {Namespace: "other", Name: "b"}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		if actual := pkg.Qualify(test.reference); !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("Qualified %+v into %+v, expected %+v", test.reference, actual, test.expected)
+		}
+	}
+}
+
+func TestParseBuildfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "buildfile")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	buildfilePath := filepath.Join(dir, BuildfileName)
+	contents := `namespace: ns
+name: app
+version: "1.0"
+type: go
+dependencies:
+  compile:
+    - lib@^1
+    - other/tool
+    - namespace: other
+      name: util
+      version: "2.0"
+      exclude:
+        - name: lib
+  test: []
+resolution:
+  conflicts: newest
+  pins: ["lib@1.2"]
+exclude:
+  - name: old
+`
+	if err := ioutil.WriteFile(buildfilePath, []byte(contents), 0644); err != nil {
+		t.Fatalf("Error writing build file: %+v", err)
+	}
+
+	parsed, err := ParseBuildfile(buildfilePath)
+	if err != nil {
+		t.Fatalf("Error parsing build file: %+v", err)
+	}
+
+	expectedCompile := []Package{
+		{Namespace: "ns", Name: "lib", Version: "^1"},
+		{Namespace: "other", Name: "tool", Version: InUseVersion},
+		{
+			Namespace: "other",
+			Name:      "util",
+			Version:   "2.0",
+			Exclude:   []Exclusion{{Namespace: "ns", Name: "lib"}},
+		},
+	}
+	if !reflect.DeepEqual(parsed.Dependencies.Compile, expectedCompile) {
+		t.Errorf("Parsed compile dependencies %+v, expected %+v", parsed.Dependencies.Compile, expectedCompile)
+	}
+
+	expectedPins := []Package{{Namespace: "ns", Name: "lib", Version: "1.2"}}
+	if !reflect.DeepEqual(parsed.Resolution.Pins, expectedPins) {
+		t.Errorf("Parsed pins %+v, expected %+v", parsed.Resolution.Pins, expectedPins)
+	}
+
+	if parsed.Resolution.Conflicts != ConflictPolicyNewest {
+		t.Errorf("Parsed conflict policy %q, expected %q", parsed.Resolution.Conflicts, ConflictPolicyNewest)
+	}
+
+	expectedExclude := []Exclusion{{Namespace: "ns", Name: "old"}}
+	if !reflect.DeepEqual(parsed.Exclude, expectedExclude) {
+		t.Errorf("Parsed exclusions %+v, expected %+v", parsed.Exclude, expectedExclude)
+	}
+
+	if parsed.AbsoluteBuildDir != filepath.Join(dir, BuildDir) {
+		t.Errorf("Build dir is %s, expected %s", parsed.AbsoluteBuildDir, filepath.Join(dir, BuildDir))
+	}
+
+	if _, err := ParseBuildfile(filepath.Join(dir, "missing.yaml")); err == nil {
+		t.Errorf("Parsing a missing build file didn't fail")
+	}
+}
@@ -0,0 +1,98 @@
+// Package sandbox runs commands in an isolated view of the file system. Only the mounted paths are
+// visible to the sandboxed process, which also has no network access
+package sandbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const (
+	// specEnv holds the spec of the sandbox a re-executed process has to enter
+	specEnv = "ZBUILD_SANDBOX_SPEC"
+)
+
+var (
+	// ErrUnsupported is returned when sandboxes can't be created on this platform
+	ErrUnsupported = errors.New("sandboxes are not supported on this platform")
+)
+
+// Mount makes a host path visible in the sandbox. Mounts are read-only unless they are writable
+type Mount struct {
+	Source   string // The path on the host
+	Target   string // The path in the sandbox
+	Writable bool
+}
+
+// Spec describes a sandbox
+type Spec struct {
+	Root   string   // An empty directory on the host that becomes the root of the sandbox
+	Dir    string   // The working directory in the sandbox
+	Env    []string // The complete environment of the sandboxed process
+	Mounts []Mount
+}
+
+// Command returns a command that re-executes the current executable with the args inside a new
+// sandbox. The executable must call Enter before doing anything else when IsSandboxed is true
+func Command(spec *Spec, args ...string) (*exec.Cmd, error) {
+	for _, mount := range spec.Mounts {
+		if _, err := os.Stat(mount.Source); err != nil {
+			return nil, fmt.Errorf("Error checking mount source %s: %+v", mount.Source, err)
+		}
+	}
+
+	specBytes, err := json.Marshal(spec)
+	if err != nil {
+		return nil, fmt.Errorf("Error encoding sandbox spec: %+v", err)
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("Error finding current executable: %+v", err)
+	}
+
+	cmd := exec.Command(executable, args...)
+	cmd.Env = []string{fmt.Sprintf("%s=%s", specEnv, specBytes)}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := isolate(cmd); err != nil {
+		return nil, err
+	}
+
+	return cmd, nil
+}
+
+// IsSandboxed returns true if the current process was started by Command
+func IsSandboxed() bool {
+	return os.Getenv(specEnv) != ""
+}
+
+// Enter sets up the sandbox of a process started by Command. Afterwards, only the mounts are visible,
+// the working directory is the spec's directory and the environment is the spec's environment
+func Enter() error {
+	spec := &Spec{}
+	if err := json.Unmarshal([]byte(os.Getenv(specEnv)), spec); err != nil {
+		return fmt.Errorf("Error decoding sandbox spec: %+v", err)
+	}
+
+	if err := enter(spec); err != nil {
+		return fmt.Errorf("Error entering sandbox: %+v", err)
+	}
+
+	os.Clearenv()
+	for _, variable := range spec.Env {
+		if parts := strings.SplitN(variable, "=", 2); len(parts) == 2 {
+			os.Setenv(parts[0], parts[1])
+		}
+	}
+
+	if err := os.Chdir(spec.Dir); err != nil {
+		return fmt.Errorf("Error changing to %s in sandbox: %+v", spec.Dir, err)
+	}
+
+	return nil
+}
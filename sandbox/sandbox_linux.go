@@ -0,0 +1,194 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"syscall"
+)
+
+const (
+	oldRootDirName = ".oldroot"
+
+	namespaceFlags = syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWNET |
+		syscall.CLONE_NEWPID | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS
+	bindFlags     = syscall.MS_BIND | syscall.MS_REC
+	remountFlags  = syscall.MS_BIND | syscall.MS_REMOUNT | syscall.MS_RDONLY
+	privateFlags  = syscall.MS_REC | syscall.MS_PRIVATE
+	noDeviceFlags = syscall.MS_NOSUID | syscall.MS_NODEV
+)
+
+// The flags reported by statfs, which differ from the corresponding mount flags
+const (
+	stNoSuid     = 0x2
+	stNoDev      = 0x4
+	stNoExec     = 0x8
+	stNoAtime    = 0x400
+	stNoDirAtime = 0x800
+	stRelAtime   = 0x1000
+)
+
+var (
+	// lockedFlags maps the statfs flags of a mount to the mount flags that must be kept when it's
+	// remounted from a user namespace
+	lockedFlags = map[int64]uintptr{
+		stNoSuid:     syscall.MS_NOSUID,
+		stNoDev:      syscall.MS_NODEV,
+		stNoExec:     syscall.MS_NOEXEC,
+		stNoAtime:    syscall.MS_NOATIME,
+		stNoDirAtime: syscall.MS_NODIRATIME,
+		stRelAtime:   syscall.MS_RELATIME,
+	}
+)
+
+// isolate runs the command in new user, mount, network, PID, IPC and UTS namespaces. The current user
+// is root in the user namespace, which allows the sandboxed process to set up its mounts
+func isolate(cmd *exec.Cmd) error {
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags:                 namespaceFlags,
+		UidMappings:                []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}},
+		GidMappings:                []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}},
+		GidMappingsEnableSetgroups: false,
+		Pdeathsig:                  syscall.SIGKILL,
+	}
+	return nil
+}
+
+// enter builds the sandbox's file system in the spec's root and makes it the root of the mount
+// namespace. Besides the spec's mounts, the sandbox gets its own /proc, an empty /tmp and the host's
+// /dev. Everything outside of the writable mounts and /tmp is read-only
+func enter(spec *Spec) error {
+	// Nothing mounted here may propagate back to the host
+	if err := syscall.Mount("", "/", "", privateFlags, ""); err != nil {
+		return fmt.Errorf("Error making mounts private: %+v", err)
+	}
+
+	// pivot_root needs the new root to be a mount point
+	if err := syscall.Mount(spec.Root, spec.Root, "", bindFlags, ""); err != nil {
+		return fmt.Errorf("Error mounting sandbox root %s: %+v", spec.Root, err)
+	}
+
+	if err := mountSystemDirs(spec.Root); err != nil {
+		return err
+	}
+
+	// Parents are mounted before their children, so children aren't hidden by them
+	mounts := append([]Mount{}, spec.Mounts...)
+	sort.SliceStable(mounts, func(i, j int) bool {
+		return len(mounts[i].Target) < len(mounts[j].Target)
+	})
+
+	for _, mount := range mounts {
+		if err := bind(mount.Source, filepath.Join(spec.Root, mount.Target), mount.Writable); err != nil {
+			return err
+		}
+	}
+
+	oldRoot := filepath.Join(spec.Root, oldRootDirName)
+	if err := os.MkdirAll(oldRoot, 0700); err != nil {
+		return fmt.Errorf("Error creating %s: %+v", oldRoot, err)
+	}
+
+	if err := syscall.PivotRoot(spec.Root, oldRoot); err != nil {
+		return fmt.Errorf("Error changing root to %s: %+v", spec.Root, err)
+	}
+
+	if err := os.Chdir("/"); err != nil {
+		return fmt.Errorf("Error changing to new root: %+v", err)
+	}
+
+	oldRoot = "/" + oldRootDirName
+	if err := syscall.Unmount(oldRoot, syscall.MNT_DETACH); err != nil {
+		return fmt.Errorf("Error unmounting the host's root: %+v", err)
+	}
+
+	if err := os.Remove(oldRoot); err != nil {
+		return fmt.Errorf("Error removing %s: %+v", oldRoot, err)
+	}
+
+	return remountReadOnly("/")
+}
+
+func mountSystemDirs(root string) error {
+	procDir := filepath.Join(root, "proc")
+	if err := os.MkdirAll(procDir, 0755); err != nil {
+		return fmt.Errorf("Error creating %s: %+v", procDir, err)
+	}
+
+	// Mounting a new proc isn't allowed in some containers, in which case the host's is used
+	if err := syscall.Mount("proc", procDir, "proc", noDeviceFlags|syscall.MS_NOEXEC, ""); err != nil {
+		if err := bind("/proc", procDir, true); err != nil {
+			return err
+		}
+	}
+
+	if err := bind("/dev", filepath.Join(root, "dev"), true); err != nil {
+		return err
+	}
+
+	tmpDir := filepath.Join(root, "tmp")
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return fmt.Errorf("Error creating %s: %+v", tmpDir, err)
+	}
+
+	if err := syscall.Mount("tmpfs", tmpDir, "tmpfs", noDeviceFlags, "mode=1777"); err != nil {
+		return fmt.Errorf("Error mounting %s: %+v", tmpDir, err)
+	}
+
+	return nil
+}
+
+// bind mounts the source at the target, creating the target if needed
+func bind(source, target string, writable bool) error {
+	info, err := os.Stat(source)
+	if err != nil {
+		return fmt.Errorf("Error checking mount source %s: %+v", source, err)
+	}
+
+	if info.IsDir() {
+		err = os.MkdirAll(target, 0755)
+	} else if err = os.MkdirAll(filepath.Dir(target), 0755); err == nil {
+		var file *os.File
+		if file, err = os.OpenFile(target, os.O_CREATE|os.O_WRONLY, 0644); err == nil {
+			err = file.Close()
+		}
+	}
+
+	if err != nil {
+		return fmt.Errorf("Error creating mount point %s: %+v", target, err)
+	}
+
+	if err := syscall.Mount(source, target, "", bindFlags, ""); err != nil {
+		return fmt.Errorf("Error mounting %s at %s: %+v", source, target, err)
+	}
+
+	if writable {
+		return nil
+	}
+
+	return remountReadOnly(target)
+}
+
+func remountReadOnly(target string) error {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(target, &stat); err != nil {
+		return fmt.Errorf("Error getting mount flags of %s: %+v", target, err)
+	}
+
+	flags := uintptr(remountFlags)
+	for statFlag, mountFlag := range lockedFlags {
+		if int64(stat.Flags)&statFlag != 0 {
+			flags |= mountFlag
+		}
+	}
+
+	if err := syscall.Mount("", target, "", flags, ""); err != nil {
+		return fmt.Errorf("Error making %s read-only: %+v", target, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package sandbox
+
+import (
+	"os/exec"
+)
+
+func isolate(cmd *exec.Cmd) error {
+	return ErrUnsupported
+}
+
+func enter(spec *Spec) error {
+	return ErrUnsupported
+}
@@ -0,0 +1,410 @@
+// Package versions contains logic for comparing package versions and matching them against
+// version constraints declared in build files
+package versions
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	constraintChars = "^~<>=*| "
+	orSeparator     = "||"
+)
+
+type operator string
+
+const (
+	opEqual        operator = "="
+	opGreater      operator = ">"
+	opGreaterEqual operator = ">="
+	opLess         operator = "<"
+	opLessEqual    operator = "<="
+)
+
+var (
+	// Longer operators must come first so they are matched before their prefixes
+	operators = []operator{opGreaterEqual, opLessEqual, opGreater, opLess, opEqual}
+)
+
+// IsConstraint returns true if the version string should be interpreted as a constraint, e.g. ^1.2,
+// rather than as an exact version
+func IsConstraint(version string) bool {
+	if strings.ContainsAny(version, constraintChars) {
+		return true
+	}
+
+	for _, component := range strings.Split(version, ".") {
+		if isWildcard(component) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isWildcard(component string) bool {
+	return component == "x" || component == "X" || component == "*"
+}
+
+// version is a parsed version string. Versions are dot separated numbers, optionally followed by a
+// dash and a pre-release identifier, e.g. 1.2 or 2.0.1-beta
+type version struct {
+	raw        string
+	numbers    []int
+	prerelease string
+	numeric    bool
+}
+
+func parseVersion(raw string) version {
+	parsed := version{raw: raw}
+	release := raw
+	if dash := strings.Index(raw, "-"); dash >= 0 {
+		release, parsed.prerelease = raw[:dash], raw[dash+1:]
+	}
+
+	for _, component := range strings.Split(release, ".") {
+		number, err := strconv.Atoi(component)
+		if err != nil || number < 0 {
+			return parsed
+		}
+		parsed.numbers = append(parsed.numbers, number)
+	}
+
+	parsed.numeric = true
+	return parsed
+}
+
+func (v version) number(i int) int {
+	if i < len(v.numbers) {
+		return v.numbers[i]
+	}
+	return 0
+}
+
+// sameRelease returns true if the numeric components of both versions are equal, ignoring any
+// pre-release identifier
+func (v version) sameRelease(other version) bool {
+	length := len(v.numbers)
+	if len(other.numbers) > length {
+		length = len(other.numbers)
+	}
+
+	for i := 0; i < length; i++ {
+		if v.number(i) != other.number(i) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (v version) compare(other version) int {
+	if !v.numeric || !other.numeric {
+		return strings.Compare(v.raw, other.raw)
+	}
+
+	length := len(v.numbers)
+	if len(other.numbers) > length {
+		length = len(other.numbers)
+	}
+
+	for i := 0; i < length; i++ {
+		if a, b := v.number(i), other.number(i); a != b {
+			if a < b {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	// A pre-release comes before the release it precedes
+	switch {
+	case v.prerelease == other.prerelease:
+		return 0
+	case v.prerelease == "":
+		return 1
+	case other.prerelease == "":
+		return -1
+	default:
+		return strings.Compare(v.prerelease, other.prerelease)
+	}
+}
+
+// Compare returns -1, 0 or 1 if a is lower than, equal to or higher than b. Numeric components are
+// compared numerically and missing components are treated as zero, so 1.0 and 1 are equal.
+// Versions that are not numeric are compared as strings
+func Compare(a, b string) int {
+	return parseVersion(a).compare(parseVersion(b))
+}
+
+// Sort sorts the versions from lowest to highest
+func Sort(versions []string) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		return Compare(versions[i], versions[j]) < 0
+	})
+}
+
+type comparator struct {
+	op      operator
+	version version
+}
+
+func (c comparator) matches(v version) bool {
+	result := v.compare(c.version)
+	switch c.op {
+	case opEqual:
+		return result == 0
+	case opGreater:
+		return result > 0
+	case opGreaterEqual:
+		return result >= 0
+	case opLess:
+		return result < 0
+	case opLessEqual:
+		return result <= 0
+	}
+	return false
+}
+
+// Constraint is a set of version ranges. A version matches the constraint if it satisfies every
+// comparator in any one of the ranges
+type Constraint struct {
+	raw    string
+	ranges [][]comparator
+}
+
+// ParseConstraint parses a version constraint. Ranges are separated by ||, and each range is a
+// space separated list of comparators that must all match. Supported comparators are:
+//
+//	1.2        exactly 1.2
+//	>=1.2 <3   the usual comparison operators
+//	^1.2       at least 1.2, without changing the left-most non-zero component (>=1.2 <2)
+//	~1.2       at least 1.2, without changing the specified components (>=1.2 <1.3)
+//	1.x, 1.*   any version starting with 1 (x, X and * are equivalent)
+//
+// Pre-release versions, e.g. 2.0-beta, only satisfy a range if one of its comparators names a
+// pre-release of the same version, e.g. >=2.0-alpha. This keeps 3.0-beta out of >=2.0-alpha
+func ParseConstraint(raw string) (*Constraint, error) {
+	constraint := &Constraint{raw: raw}
+	for _, rangeString := range strings.Split(raw, orSeparator) {
+		tokens := strings.Fields(rangeString)
+		if len(tokens) == 0 {
+			return nil, fmt.Errorf("Empty range in version constraint %q", raw)
+		}
+
+		comparators := make([]comparator, 0)
+		for i := 0; i < len(tokens); i++ {
+			token := tokens[i]
+
+			// Allow a space between the operator and the version, e.g. ">= 1.2"
+			if isOperator(token) && i+1 < len(tokens) {
+				i++
+				token += tokens[i]
+			}
+
+			parsed, err := parseComparator(token)
+			if err != nil {
+				return nil, fmt.Errorf("Error parsing version constraint %q: %+v", raw, err)
+			}
+			comparators = append(comparators, parsed...)
+		}
+
+		constraint.ranges = append(constraint.ranges, comparators)
+	}
+
+	return constraint, nil
+}
+
+func isOperator(token string) bool {
+	for _, op := range operators {
+		if token == string(op) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseComparator(token string) ([]comparator, error) {
+	switch {
+	case strings.HasPrefix(token, "^"):
+		return caretRange(token[1:])
+	case strings.HasPrefix(token, "~"):
+		return tildeRange(token[1:])
+	}
+
+	for _, op := range operators {
+		if strings.HasPrefix(token, string(op)) {
+			parsed, err := parseNumericVersion(token[len(op):])
+			if err != nil {
+				return nil, err
+			}
+			return []comparator{{op: op, version: parsed}}, nil
+		}
+	}
+
+	return wildcardRange(token)
+}
+
+func parseNumericVersion(raw string) (version, error) {
+	parsed := parseVersion(raw)
+	if !parsed.numeric {
+		return parsed, fmt.Errorf("%q is not a numeric version", raw)
+	}
+	return parsed, nil
+}
+
+// caretRange allows changes that do not modify the left-most non-zero component
+func caretRange(raw string) ([]comparator, error) {
+	lower, err := parseNumericVersion(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	bump := len(lower.numbers) - 1
+	for i, number := range lower.numbers {
+		if number != 0 {
+			bump = i
+			break
+		}
+	}
+
+	return []comparator{
+		{op: opGreaterEqual, version: lower},
+		{op: opLess, version: incremented(lower, bump)},
+	}, nil
+}
+
+// tildeRange allows changes to components that were not specified, e.g. ~1.2 allows 1.2.x
+func tildeRange(raw string) ([]comparator, error) {
+	lower, err := parseNumericVersion(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	bump := len(lower.numbers) - 1
+	if bump > 1 {
+		bump = 1
+	}
+
+	return []comparator{
+		{op: opGreaterEqual, version: lower},
+		{op: opLess, version: incremented(lower, bump)},
+	}, nil
+}
+
+// wildcardRange handles exact versions and versions with wildcard components such as 1.x
+func wildcardRange(raw string) ([]comparator, error) {
+	components := strings.Split(raw, ".")
+	fixed := make([]string, 0)
+	for _, component := range components {
+		if isWildcard(component) {
+			break
+		}
+		fixed = append(fixed, component)
+	}
+
+	if len(fixed) == len(components) {
+		exact, err := parseNumericVersion(raw)
+		if err != nil {
+			return nil, err
+		}
+		return []comparator{{op: opEqual, version: exact}}, nil
+	}
+
+	if len(fixed) == 0 {
+		// A bare wildcard matches everything
+		return []comparator{}, nil
+	}
+
+	lower, err := parseNumericVersion(strings.Join(fixed, "."))
+	if err != nil {
+		return nil, err
+	}
+
+	return []comparator{
+		{op: opGreaterEqual, version: lower},
+		{op: opLess, version: incremented(lower, len(fixed)-1)},
+	}, nil
+}
+
+// incremented returns a release version with the component at index incremented and all components
+// after it dropped, e.g. incrementing index 0 of 1.2 produces 2
+func incremented(v version, index int) version {
+	numbers := make([]int, index+1)
+	copy(numbers, v.numbers)
+	numbers[index]++
+
+	components := make([]string, len(numbers))
+	for i, number := range numbers {
+		components[i] = strconv.Itoa(number)
+	}
+
+	return version{raw: strings.Join(components, "."), numbers: numbers, numeric: true}
+}
+
+// String returns the constraint as it was written
+func (c *Constraint) String() string {
+	return c.raw
+}
+
+// Matches returns true if the version satisfies the constraint. Versions that are not numeric
+// never satisfy a constraint
+func (c *Constraint) Matches(raw string) bool {
+	parsed := parseVersion(raw)
+	if !parsed.numeric {
+		return false
+	}
+
+	for _, comparators := range c.ranges {
+		if rangeMatches(comparators, parsed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func rangeMatches(comparators []comparator, v version) bool {
+	for _, comparator := range comparators {
+		if !comparator.matches(v) {
+			return false
+		}
+	}
+
+	if v.prerelease == "" {
+		return true
+	}
+
+	for _, comparator := range comparators {
+		if comparator.version.prerelease != "" && comparator.version.sameRelease(v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Highest returns the highest of the versions that satisfies the constraint. The second return value
+// is false if no version satisfies the constraint
+func (c *Constraint) Highest(versions []string) (string, bool) {
+	highest := ""
+	found := false
+	for _, candidate := range versions {
+		if !c.Matches(candidate) {
+			continue
+		}
+
+		// Ties, e.g. 1.0 and 1, are broken by the raw string so the choice doesn't depend on the
+		// order of the candidates
+		if !found || Compare(candidate, highest) > 0 ||
+			(Compare(candidate, highest) == 0 && candidate > highest) {
+			highest = candidate
+			found = true
+		}
+	}
+
+	return highest, found
+}
@@ -0,0 +1,171 @@
+package versions
+
+import (
+	"testing"
+)
+
+func TestIsConstraint(t *testing.T) {
+	tests := []struct {
+		version  string
+		expected bool
+	}{
+		{"1.2", false},
+		{"1.2.3-beta", false},
+		{"^1.2", true},
+		{"~1.2", true},
+		{">=1.2", true},
+		{"1.x", true},
+		{"1.*", true},
+		{"*", true},
+		{"1 || 2", true},
+	}
+
+	for _, test := range tests {
+		if actual := IsConstraint(test.version); actual != test.expected {
+			t.Errorf("IsConstraint(%q) = %t, expected %t", test.version, actual, test.expected)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"1.0", "1", 0},
+		{"1.2", "1.10", -1},
+		{"2.0", "1.9.9", 1},
+		{"2.0-beta", "2.0", -1},
+		{"2.0-alpha", "2.0-beta", -1},
+		{"2.0-beta", "1.9", 1},
+	}
+
+	for _, test := range tests {
+		if actual := Compare(test.a, test.b); actual != test.expected {
+			t.Errorf("Compare(%q, %q) = %d, expected %d", test.a, test.b, actual, test.expected)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		constraint string
+		version    string
+		expected   bool
+	}{
+		// Exact versions
+		{"1.2", "1.2", true},
+		{"1.2", "1.2.0", true},
+		{"1.2", "1.3", false},
+		{"=1.2", "1.2", true},
+
+		// Caret
+		{"^1.2", "1.2", true},
+		{"^1.2", "1.9.3", true},
+		{"^1.2", "2.0", false},
+		{"^1.2", "1.1", false},
+		{"^0.2.3", "0.2.9", true},
+		{"^0.2.3", "0.3.0", false},
+		{"^0.0.3", "0.0.3", true},
+		{"^0.0.3", "0.0.4", false},
+		{"^0", "0.9", true},
+		{"^0", "1.0", false},
+
+		// Tilde
+		{"~1.2", "1.2.9", true},
+		{"~1.2", "1.3", false},
+		{"~1.2.3", "1.2.9", true},
+		{"~1.2.3", "1.3.0", false},
+		{"~1", "1.9", true},
+		{"~1", "2.0", false},
+
+		// Wildcards
+		{"1.x", "1.5", true},
+		{"1.X", "2.0", false},
+		{"1.2.*", "1.2.7", true},
+		{"1.2.*", "1.3", false},
+		{"*", "42.0", true},
+
+		// Comparators
+		{">=1.2", "1.2", true},
+		{">= 1.2", "1.3", true},
+		{">= 1.2", "1.1", false},
+		{">1.2 <=2", "2.0", true},
+		{">1.2 <=2", "1.2", false},
+		{"<2", "1.9.9", true},
+
+		// Alternatives
+		{"1.x || >=3", "1.4", true},
+		{"1.x || >=3", "2.0", false},
+		{"1.x || >=3", "3.1", true},
+
+		// Pre-releases
+		{"^1.2", "1.3-beta", false},
+		{"<2", "2.0-beta", false},
+		{">=2.0-alpha", "2.0-beta", true},
+		{">=2.0-alpha", "2.0", true},
+		{">=2.0-alpha", "3.0-beta", false},
+		{"^1.2.3-beta", "1.2.3-rc", true},
+		{"^1.2.3-beta", "1.2.4-rc", false},
+		{"^1.2.3-beta || 2.x", "2.0-rc", false},
+		{"2.0-beta", "2.0-beta", true},
+
+		// Versions that aren't numeric never match
+		{"*", "latest", false},
+	}
+
+	for _, test := range tests {
+		constraint, err := ParseConstraint(test.constraint)
+		if err != nil {
+			t.Errorf("ParseConstraint(%q) returned an error: %+v", test.constraint, err)
+			continue
+		}
+
+		if actual := constraint.Matches(test.version); actual != test.expected {
+			t.Errorf("%q matches %q = %t, expected %t", test.constraint, test.version, actual, test.expected)
+		}
+	}
+}
+
+func TestParseConstraintErrors(t *testing.T) {
+	for _, raw := range []string{"", "1 ||", "^abc", ">=x.y", "~"} {
+		if _, err := ParseConstraint(raw); err == nil {
+			t.Errorf("ParseConstraint(%q) didn't return an error", raw)
+		}
+	}
+}
+
+func TestHighest(t *testing.T) {
+	candidates := []string{"1.0", "1.2", "1.10", "2.0-beta", "2.0", "2.1", "3.0-rc"}
+	tests := []struct {
+		constraint string
+		expected   string
+		found      bool
+	}{
+		{"^1.0", "1.10", true},
+		{"~1.2", "1.2", true},
+		{">=2.0-alpha <3", "2.1", true},
+		{">=3.0-alpha", "3.0-rc", true},
+		{"*", "2.1", true},
+		{"^4", "", false},
+	}
+
+	for _, test := range tests {
+		constraint, err := ParseConstraint(test.constraint)
+		if err != nil {
+			t.Errorf("ParseConstraint(%q) returned an error: %+v", test.constraint, err)
+			continue
+		}
+
+		highest, found := constraint.Highest(candidates)
+		if highest != test.expected || found != test.found {
+			t.Errorf("%q: Highest() = (%q, %t), expected (%q, %t)", test.constraint, highest, found,
+				test.expected, test.found)
+		}
+	}
+
+	constraint, _ := ParseConstraint("1.0")
+	if highest, _ := constraint.Highest([]string{"1", "1.0"}); highest != "1.0" {
+		t.Errorf("Highest() with equal candidates = %q, expected 1.0", highest)
+	}
+}
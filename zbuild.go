@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	"github.com/dimes/zbuild/buildlog"
+	"github.com/dimes/zbuild/local"
 	"github.com/dimes/zbuild/model"
 )
 
@@ -15,9 +16,26 @@ var (
 // Builder is an interface that all builders must implement
 type Builder interface {
 	Type() string
+
+	// Version identifies the builder's logic. Changing it makes every package of its type rebuild
+	Version() string
+
 	Build(workspace string, parsedBuildfile *model.ParsedBuildfile) error
 }
 
+// EnvBuilder is implemented by builders that set variables of their own in the build environment,
+// e.g. GOPATH. They take precedence over every other variable
+type EnvBuilder interface {
+	Env(workspace string, parsedBuildfile *model.ParsedBuildfile, env *local.BuildEnv) error
+}
+
+// ToolchainBuilder is implemented by builders that run an external toolchain, e.g. go or protoc.
+// Toolchain identifies its version and target platform in the build environment, so that build
+// outputs are only shared between machines that build with the same toolchain
+type ToolchainBuilder interface {
+	Toolchain(env *local.BuildEnv) (string, error)
+}
+
 // RegisterBuilder associates the given builder with its type. If the type already has
 // a builder associated with it, then this method will return an error. This method is not
 // safe for concurrent calls
@@ -31,7 +49,50 @@ func RegisterBuilder(builder Builder) error {
 	return nil
 }
 
+// GetBuildEnv returns the environment the builder builds the package with
+func GetBuildEnv(builder Builder, workspace string, parsedBuildfile *model.ParsedBuildfile) (*local.BuildEnv, error) {
+	env, err := local.GetBuildEnv(workspace, parsedBuildfile)
+	if err != nil {
+		return nil, err
+	}
+
+	if envBuilder, ok := builder.(EnvBuilder); ok {
+		if err := envBuilder.Env(workspace, parsedBuildfile, env); err != nil {
+			return nil, fmt.Errorf("Error setting %s builder variables: %+v", builder.Type(), err)
+		}
+	}
+
+	return env, nil
+}
+
 // GetBuilderForType returns a builder for the given type, or nil if no such builder is registered
 func GetBuilderForType(builderType string) Builder {
 	return builders[builderType]
 }
+
+// GetBuildCacheKey returns the key that the builder's output for the package is stored under in the
+// build cache. The digest is the digest of the package's fingerprint
+func GetBuildCacheKey(builder Builder, workspace string, parsedBuildfile *model.ParsedBuildfile, digest string,
+	hermetic bool) (string, error) {
+	// The key includes the host variables as the host provides them, before the builder derives its
+	// own variables from them
+	hostEnv, err := local.GetBuildEnv(workspace, parsedBuildfile)
+	if err != nil {
+		return "", fmt.Errorf("Error generating build environment: %+v", err)
+	}
+
+	toolchain := ""
+	if toolchainBuilder, ok := builder.(ToolchainBuilder); ok {
+		env, err := GetBuildEnv(builder, workspace, parsedBuildfile)
+		if err != nil {
+			return "", err
+		}
+
+		toolchain, err = toolchainBuilder.Toolchain(env)
+		if err != nil {
+			return "", fmt.Errorf("Error identifying the %s toolchain: %+v", builder.Type(), err)
+		}
+	}
+
+	return local.GetBuildCacheKey(digest, hostEnv, toolchain, hermetic), nil
+}